@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+)
+
+// blobsDirName is the directory below the backup that holds the attachments
+// with --attachments=blobs.
+const blobsDirName = "blobs"
+
+var attachmentsFlag = flag.String("attachments", "copy", "How attachments are stored: copy (in every note's directory) or blobs (once in blobs/, linked from the notes; blobs/ is never cleaned up)")
+
+// blobStore keeps resource bodies by their MD5 hash, in <dir>/ab/cdef...
+// The store only grows: blobs stay when the notes that linked them are
+// changed or deleted, so that older snapshots and backups of the backup
+// never lose their attachments.
+type blobStore string
+
+// blobs returns the blob store of the backup, or "" if attachments are
+// copied into every note.
+func blobs(destDir string) blobStore {
+	if *attachmentsFlag != "blobs" {
+		return ""
+	}
+	return blobStore(filepath.Join(destDir, blobsDirName))
+}
+
+func (s blobStore) path(hash string) string {
+	return filepath.Join(string(s), hash[:2], hash[2:])
+}
+
+// get returns the body with the given hash, if it is in the store and not
+// corrupt.
+func (s blobStore) get(hash string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(s.path(hash))
+	if err != nil {
+		return nil, false
+	}
+	if sum := md5.Sum(b); hex.EncodeToString(sum[:]) != hash {
+		log.Printf("Blob %s is corrupt, downloading it again", hash)
+		return nil, false
+	}
+	return b, true
+}
+
+// put stores a body, unless the store already has an intact copy of it. A
+// corrupt copy is replaced; notes are only relinked to the new copy when
+// they are written again.
+func (s blobStore) put(hash string, body []byte) error {
+	if b, err := ioutil.ReadFile(s.path(hash)); err == nil && bytes.Equal(b, body) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(hash)), 0755); err != nil {
+		return err
+	}
+	return fileutil.WriteFile(s.path(hash), body, 0444)
+}
+
+// link makes filename refer to the blob with the given hash. Hard links are
+// used where possible, symbolic links otherwise.
+func (s blobStore) link(hash, filename string) error {
+	os.Remove(filename)
+	if err := os.Link(s.path(hash), filename); err == nil {
+		return nil
+	}
+	target, err := filepath.Rel(filepath.Dir(filename), s.path(hash))
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, filename)
+}
+
+// loadData fills in the body of a resource that was fetched without data,
+// from the store if possible, from the server otherwise.
+func (s blobStore) loadData(ctx context.Context, t *backupTarget, res *edam.Resource) error {
+	if res.Data == nil {
+		return fmt.Errorf("resource %s has no data", res.GetGUID())
+	}
+	hash := hex.EncodeToString(res.Data.BodyHash)
+	if body, ok := s.get(hash); ok {
+		res.Data.Body = body
+		return nil
+	}
+	log.Printf("Downloading attachment %s", hash)
+	body, err := t.ns.GetResourceData(ctx, t.authToken, res.GetGUID())
+	if err != nil {
+		return err
+	}
+	res.Data.Body = body
+	return nil
+}
+
+// writeAttachment writes a resource body to filename, or links it from the
+// blob store of the backup in destDir.
+func writeAttachment(destDir, filename, hash string, body []byte) error {
+	s := blobs(destDir)
+	if s == "" {
+		return fileutil.WriteFile(filename, body, 0644)
+	}
+	if err := s.put(hash, body); err != nil {
+		return err
+	}
+	return s.link(hash, filename)
+}
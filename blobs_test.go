@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobAttachments(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	*attachmentsFlag = "blobs"
+	defer func() { *attachmentsFlag = "copy" }()
+
+	body := []byte("attachment")
+	sum := md5.Sum(body)
+	hash := hex.EncodeToString(sum[:])
+	for _, note := range []string{"a", "b"} {
+		filename := filepath.Join(destDir, note, "files", "x.txt")
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeAttachment(destDir, filename, hash, body); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadFile(filename); err != nil || string(b) != string(body) {
+			t.Errorf("Expected %q in %s, got %q (%v)", body, filename, b, err)
+		}
+	}
+
+	blobs, _ := filepath.Glob(filepath.Join(destDir, blobsDirName, "*", "*"))
+	if len(blobs) != 1 || blobs[0] != filepath.Join(destDir, blobsDirName, hash[:2], hash[2:]) {
+		t.Errorf("Expected exactly one blob, got %v", blobs)
+	}
+	store := blobStore(filepath.Join(destDir, blobsDirName))
+	if b, ok := store.get(hash); !ok || string(b) != string(body) {
+		t.Errorf("Expected blob %s to be found", hash)
+	}
+
+	// A corrupt blob of the same size is replaced.
+	os.Remove(store.path(hash))
+	if err := ioutil.WriteFile(store.path(hash), []byte("corrupted!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(destDir, "a", "files", "x.txt")
+	if err := writeAttachment(destDir, filename, hash, body); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filename); err != nil || string(b) != string(body) {
+		t.Errorf("Expected corrupt blob to be replaced, got %q (%v)", b, err)
+	}
+}
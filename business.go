@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+// syncBusiness backs up all business notebooks the user can access. Business
+// notes are stored next to the personal ones, but are marked with their
+// source in the repository and have their own sync state.
+func syncBusiness(destDir string) error {
+	us, err := client.getUserStore()
+	if err != nil {
+		return err
+	}
+	t, err := businessTarget(runContext, us, client.authToken, client.getNoteStoreForURL, destDir)
+	if err != nil || t == nil {
+		return err
+	}
+	return t.sync()
+}
+
+// businessTarget returns the target for the business notebooks of the user,
+// or nil if the user is not part of an Evernote Business account.
+func businessTarget(ctx context.Context, us edam.UserStore, authToken string, noteStore func(url string) (edam.NoteStore, error), destDir string) (*backupTarget, error) {
+	user, err := us.GetUser(ctx, authToken)
+	if err != nil {
+		return nil, err
+	}
+	if user.BusinessUserInfo == nil {
+		log.Printf("Account is not part of Evernote Business, skipping business notebooks")
+		return nil, nil
+	}
+	authResult, err := us.AuthenticateToBusiness(ctx, authToken)
+	if err != nil {
+		return nil, err
+	}
+	businessNs, err := noteStore(authResult.GetNoteStoreUrl())
+	if err != nil {
+		return nil, err
+	}
+
+	t := &backupTarget{
+		ns:        businessNs,
+		authToken: authResult.AuthenticationToken,
+		destDir:   destDir,
+		source:    repository.SourceBusiness,
+
+		noteStoreURL: authResult.GetNoteStoreUrl(),
+	}
+	notebooks, err := t.ns.ListAccessibleBusinessNotebooks(ctx, t.authToken)
+	if err != nil {
+		return nil, err
+	}
+	var notebookGUIDs []string
+	for _, nb := range notebooks {
+		log.Printf("Found business notebook %q", nb.GetName())
+		notebookGUIDs = append(notebookGUIDs, string(nb.GetGUID()))
+	}
+
+	// Notebooks we didn't know about so far need a full sync, an incremental
+	// one would only return their notes that changed recently.
+	repo, err := repository.Load(t.destDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, guid := range notebookGUIDs {
+		if _, ok := repo.Notebook(guid); !ok {
+			t.forceFullSync = true
+		}
+	}
+
+	t.syncState = func(ctx context.Context) (*edam.SyncState, error) {
+		return t.ns.GetSyncState(ctx, t.authToken)
+	}
+	t.syncChunk = func(ctx context.Context, afterUSN int32, fullSync bool) (*edam.SyncChunk, error) {
+		filter := syncChunkFilter(!fullSync)
+		filter.NotebookGuids = notebookGUIDs
+		return t.ns.GetFilteredSyncChunk(ctx, t.authToken, afterUSN, maxSyncChunkEntries, filter)
+	}
+	t.listTags = func(ctx context.Context) ([]*edam.Tag, error) {
+		return t.ns.ListTags(ctx, t.authToken)
+	}
+	return t, nil
+}
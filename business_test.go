@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+// fakeUserStore is a user store that only knows the calls the business
+// target makes.
+type fakeUserStore struct {
+	edam.UserStore
+	user *edam.User
+}
+
+func (s *fakeUserStore) GetUser(ctx context.Context, authenticationToken string) (*edam.User, error) {
+	return s.user, nil
+}
+
+func (s *fakeUserStore) AuthenticateToBusiness(ctx context.Context, authenticationToken string) (*edam.AuthenticationResult_, error) {
+	url := "https://business.example.com/notestore"
+	return &edam.AuthenticationResult_{AuthenticationToken: "business-token", NoteStoreUrl: &url}, nil
+}
+
+// fakeNoteStore is a note store with a single sync chunk.
+type fakeNoteStore struct {
+	edam.NoteStore
+	state edam.SyncState
+	chunk *edam.SyncChunk
+	notes map[edam.GUID]*edam.Note
+	// fetched are the notes that were downloaded. If getNote is set, it is
+	// called before a note is returned and can fail the download.
+	fetched []edam.GUID
+	getNote func(guid edam.GUID) error
+}
+
+func (s *fakeNoteStore) GetSyncState(ctx context.Context, authenticationToken string) (*edam.SyncState, error) {
+	return &s.state, nil
+}
+
+func (s *fakeNoteStore) GetFilteredSyncChunk(ctx context.Context, authenticationToken string, afterUSN int32, maxEntries int32, filter *edam.SyncChunkFilter) (*edam.SyncChunk, error) {
+	return s.chunk, nil
+}
+
+func (s *fakeNoteStore) ListAccessibleBusinessNotebooks(ctx context.Context, authenticationToken string) ([]*edam.Notebook, error) {
+	return s.chunk.Notebooks, nil
+}
+
+func (s *fakeNoteStore) ListTags(ctx context.Context, authenticationToken string) ([]*edam.Tag, error) {
+	return s.chunk.Tags, nil
+}
+
+func (s *fakeNoteStore) GetNoteWithResultSpec(ctx context.Context, authenticationToken string, guid edam.GUID, resultSpec *edam.NoteResultSpec) (*edam.Note, error) {
+	if s.getNote != nil {
+		if err := s.getNote(guid); err != nil {
+			return nil, err
+		}
+	}
+	s.fetched = append(s.fetched, guid)
+	return s.notes[guid], nil
+}
+
+func newFakeNoteStore() *fakeNoteStore {
+	nbGUID, nbName := edam.GUID("nb1"), "Team"
+	tagGUID, tagName := edam.GUID("tag1"), "Shared"
+	noteGUID, title, content, active := edam.GUID("note1"), "Plan", enmlHeader+"<en-note>Hi</en-note>", true
+	noteNotebook := string(nbGUID)
+	note := &edam.Note{GUID: &noteGUID, Title: &title, Content: &content, NotebookGuid: &noteNotebook, Active: &active, UpdateSequenceNum: usn(3), TagGuids: []edam.GUID{tagGUID}, TagNames: []string{tagName}}
+	return &fakeNoteStore{
+		state: edam.SyncState{UpdateCount: 5},
+		chunk: &edam.SyncChunk{
+			ChunkHighUSN: usn(5),
+			UpdateCount:  5,
+			Notes:        []*edam.Note{note},
+			Notebooks:    []*edam.Notebook{{GUID: &nbGUID, Name: &nbName}},
+			Tags:         []*edam.Tag{{GUID: &tagGUID, Name: &tagName}},
+		},
+		notes: map[edam.GUID]*edam.Note{noteGUID: note},
+	}
+}
+
+func TestBusinessSync(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "business")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	defer func(f []string) { formats = f }(formats)
+	formats = []string{"html"}
+
+	// The personal account was synced before
+	repo := repository.New(destDir)
+	repo.SetSyncState("", repository.SyncState{UpdateCount: 42, LastSync: 1})
+	if err := repo.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := newFakeNoteStore()
+	us := &fakeUserStore{user: &edam.User{BusinessUserInfo: &edam.BusinessUserInfo{}}}
+	target, err := businessTarget(context.Background(), us, "token", func(url string) (edam.NoteStore, error) { return ns, nil }, destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target == nil || target.source != repository.SourceBusiness || target.authToken != "business-token" {
+		t.Fatalf("Unexpected business target %+v", target)
+	}
+	if !target.forceFullSync {
+		t.Errorf("Expected a full sync for new business notebooks")
+	}
+	if err := target.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err = repository.Load(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nb, ok := repo.Notebook("nb1"); !ok || nb.Source != repository.SourceBusiness {
+		t.Errorf("Expected business notebook, got %+v", nb)
+	}
+	if tag, ok := repo.Tag("tag1"); !ok || tag.Source != repository.SourceBusiness {
+		t.Errorf("Expected business tag, got %+v", tag)
+	}
+	if e, ok := repo.Get("note1"); !ok || e.Source != repository.SourceBusiness {
+		t.Errorf("Expected business note, got %+v", e)
+	}
+	if got := repo.SyncState(repository.SourceBusiness).UpdateCount; got != 5 {
+		t.Errorf("Expected business update count 5, got %d", got)
+	}
+	if got := repo.SyncState("").UpdateCount; got != 42 {
+		t.Errorf("Expected personal update count to stay at 42, got %d", got)
+	}
+}
+
+func TestBusinessTargetWithoutBusiness(t *testing.T) {
+	us := &fakeUserStore{user: &edam.User{}}
+	noteStore := func(url string) (edam.NoteStore, error) {
+		t.Errorf("Unexpected note store for %s", url)
+		return nil, nil
+	}
+	target, err := businessTarget(context.Background(), us, "token", noteStore, "/backup")
+	if err != nil || target != nil {
+		t.Errorf("Expected no business target, got %+v (%v)", target, err)
+	}
+}
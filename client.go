@@ -108,6 +108,9 @@ type evernoteClient struct {
 	authToken   string
 	oauthClient *oauth.Consumer
 	userStore   edam.UserStore
+	// noteStoreURL is the URL of the user's note store, known after
+	// getNoteStore was called.
+	noteStoreURL string
 }
 
 func newEvernoteClient(envType environmentType) *evernoteClient {
@@ -162,8 +165,12 @@ func (c *evernoteClient) getNoteStore(ctx context.Context) (edam.NoteStore, erro
 	if err != nil {
 		return nil, err
 	}
+	c.noteStoreURL = userUrls.GetNoteStoreUrl()
+	return c.getNoteStoreForURL(c.noteStoreURL)
+}
 
-	thriftTransport, err := thrift.NewTHttpClient(userUrls.GetNoteStoreUrl())
+func (c *evernoteClient) getNoteStoreForURL(url string) (edam.NoteStore, error) {
+	thriftTransport, err := thrift.NewTHttpClient(url)
 	thriftClient := thrift.NewTStandardClient(thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(thriftTransport), thrift.NewTBinaryProtocolFactory(true, true).GetProtocol(thriftTransport))
 	if err != nil {
 		return nil, err
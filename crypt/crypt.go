@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package crypt decrypts the content of the en-crypt elements that Evernote
+// uses for encrypted sections of notes.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrWrongPassphrase is returned if a section can't be decrypted with the
+// given passphrase.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+const (
+	aesHeader     = "ENC0"
+	aesSaltSize   = 16
+	aesIterations = 50000
+)
+
+// Decrypt decrypts the base64 encoded content of an en-crypt element and
+// returns the ENML it contains. cipher and length are the element's
+// attributes; as in ENML, an empty cipher means RC2 with 64 bits.
+func Decrypt(cipher string, length int, content, passphrase string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(content), ""))
+	if err != nil {
+		return "", err
+	}
+	var plain []byte
+	switch strings.ToUpper(cipher) {
+	case "", "RC2":
+		if length == 0 {
+			length = 64
+		}
+		plain, err = decryptRC2(data, length, passphrase)
+	case "AES":
+		if length == 0 {
+			length = 128
+		}
+		plain, err = decryptAES(data, length, passphrase)
+	default:
+		return "", fmt.Errorf("unsupported cipher %q", cipher)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(plain) {
+		return "", ErrWrongPassphrase
+	}
+	return string(plain), nil
+}
+
+// decryptRC2 decrypts the legacy format: RC2 in ECB mode with the MD5 of the
+// passphrase as key. The plaintext is zero padded and starts with 4 bytes of
+// the CRC32 of the rest, which tells whether the passphrase was correct.
+func decryptRC2(data []byte, length int, passphrase string) ([]byte, error) {
+	if len(data) == 0 || len(data)%rc2BlockSize != 0 {
+		return nil, errors.New("invalid RC2 data")
+	}
+	key := md5.Sum([]byte(passphrase))
+	c := newRC2(key[:], length)
+	plain := make([]byte, len(data))
+	for i := 0; i < len(data); i += rc2BlockSize {
+		c.decrypt(plain[i:i+rc2BlockSize], data[i:i+rc2BlockSize])
+	}
+	if len(plain) < 4 {
+		return nil, ErrWrongPassphrase
+	}
+	checksum, text := plain[:4], bytes.TrimRight(plain[4:], "\x00")
+	crc := crc32.ChecksumIEEE(text)
+	var binaryCRC [4]byte
+	binary.BigEndian.PutUint32(binaryCRC[:], crc)
+	hexCRC := fmt.Sprintf("%08X", crc)[:4]
+	if !bytes.Equal(checksum, binaryCRC[:]) && !strings.EqualFold(string(checksum), hexCRC) {
+		return nil, ErrWrongPassphrase
+	}
+	return text, nil
+}
+
+// decryptAES decrypts the format Evernote uses since 2014: "ENC0", a salt
+// for the key, a salt for the HMAC key, the IV, the AES-CBC encrypted
+// plaintext and an HMAC-SHA256 of everything before it. Both keys are
+// derived from the passphrase with PBKDF2-HMAC-SHA256.
+func decryptAES(data []byte, length int, passphrase string) ([]byte, error) {
+	headerSize := len(aesHeader) + 2*aesSaltSize + aes.BlockSize
+	if len(data) < headerSize+sha256.Size || !bytes.HasPrefix(data, []byte(aesHeader)) {
+		return nil, errors.New("invalid AES data")
+	}
+	salt := data[len(aesHeader) : len(aesHeader)+aesSaltSize]
+	hmacSalt := data[len(aesHeader)+aesSaltSize : len(aesHeader)+2*aesSaltSize]
+	iv := data[len(aesHeader)+2*aesSaltSize : headerSize]
+	body, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	ciphertext := body[headerSize:]
+
+	h := hmac.New(sha256.New, pbkdf2([]byte(passphrase), hmacSalt, aesIterations, length/8))
+	h.Write(body)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, ErrWrongPassphrase
+	}
+	if len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid AES data")
+	}
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, aesIterations, length/8))
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ciphertext)
+	return unpad(plain), nil
+}
+
+// unpad removes PKCS#7 padding, if there is any.
+func unpad(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	n := int(b[len(b)-1])
+	if n == 0 || n > aes.BlockSize || n > len(b) {
+		return b
+	}
+	for _, c := range b[len(b)-n:] {
+		if int(c) != n {
+			return b
+		}
+	}
+	return b[:len(b)-n]
+}
+
+// pbkdf2 derives a key with PBKDF2-HMAC-SHA256 as defined in RFC 8018.
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
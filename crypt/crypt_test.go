@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestRC2(t *testing.T) {
+	// Test vectors from RFC 2268
+	for _, tc := range []struct {
+		key    string
+		bits   int
+		pt, ct string
+	}{
+		{"0000000000000000", 63, "0000000000000000", "ebb773f993278eff"},
+		{"ffffffffffffffff", 64, "ffffffffffffffff", "278b27e42e2f0d49"},
+		{"3000000000000000", 64, "1000000000000001", "30649edf9be7d2c2"},
+		{"88", 64, "0000000000000000", "61a8a244adacccf0"},
+		{"88bca90e90875a", 64, "0000000000000000", "6ccf4308974c267f"},
+		{"88bca90e90875a7f0f79c384627bafb2", 64, "0000000000000000", "1a807d272bbe5db1"},
+		{"88bca90e90875a7f0f79c384627bafb2", 128, "0000000000000000", "2269552ab0f85ca6"},
+	} {
+		key, _ := hex.DecodeString(tc.key)
+		ct, _ := hex.DecodeString(tc.ct)
+		pt := make([]byte, rc2BlockSize)
+		newRC2(key, tc.bits).decrypt(pt, ct)
+		if got := hex.EncodeToString(pt); got != tc.pt {
+			t.Errorf("Key %s with %d bits: expected %s, got %s", tc.key, tc.bits, tc.pt, got)
+		}
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	expected := "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"
+	if got := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), 2, 32)); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestDecryptAES(t *testing.T) {
+	passphrase := "secret"
+	plain := []byte("<div>Hello, world</div>")
+	salt := bytes.Repeat([]byte{1}, aesSaltSize)
+	hmacSalt := bytes.Repeat([]byte{2}, aesSaltSize)
+	iv := bytes.Repeat([]byte{3}, aes.BlockSize)
+
+	n := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(plain, bytes.Repeat([]byte{byte(n)}, n)...)
+	block, _ := aes.NewCipher(pbkdf2([]byte(passphrase), salt, aesIterations, 16))
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	data := append([]byte(aesHeader), salt...)
+	data = append(data, hmacSalt...)
+	data = append(data, iv...)
+	data = append(data, ciphertext...)
+	h := hmac.New(sha256.New, pbkdf2([]byte(passphrase), hmacSalt, aesIterations, 16))
+	h.Write(data)
+	data = h.Sum(data)
+	content := base64.StdEncoding.EncodeToString(data)
+
+	got, err := Decrypt("AES", 128, content, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(plain) {
+		t.Errorf("Expected %q, got %q", plain, got)
+	}
+	if _, err := Decrypt("AES", 128, content, "wrong"); err != ErrWrongPassphrase {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+}
@@ -26,15 +26,16 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"mime"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
 	"github.com/asig/duplikator/repository"
 	"github.com/asig/duplikator/tokenstore"
 
@@ -46,18 +47,92 @@ var (
 	client *evernoteClient
 
 	destDirFlag = flag.String("dest_dir", "/tmp/evernote-backup", "Destination directory");
+	exportDirFlag = flag.String("export_dir", "/tmp/evernote-export", "Destination directory of the 'export' command")
 	sandboxFlag = flag.Bool("sandbox", false, "Use sandbox server if true")
+	businessFlag = flag.Bool("business", false, "Also back up Evernote Business notebooks")
 
 	obfuscateFlag = flag.Bool("obfuscate", false, "")
 )
 
+const lockFileName = ".duplikator.lock"
+
+// checkpointInterval is how often the repository is saved during a sync.
+var checkpointInterval = 30 * time.Second
+
 type noteWithResources struct {
 	note      *edam.Note
 	resources map[string]*edam.Resource
+	destDir   string
+	notebook  *repository.Notebook
+	// linked maps GUIDs of notes this note might link to to their titles.
+	linked map[string]string
+	// htmlPaths maps GUIDs of notes this note might link to to their HTML
+	// files. If set, links to these notes are rewritten to local links.
+	htmlPaths map[string]string
+	// versions are earlier versions of the note that are not backed up yet.
+	versions []noteWithResources
+}
+
+// backupTarget is a note store together with the token to access it and the
+// directory it is backed up to.
+type backupTarget struct {
+	ns        edam.NoteStore
+	authToken string
+	destDir   string
+	// noteStoreURL is used to create a note store for every worker, as
+	// note stores can't be shared between goroutines.
+	noteStoreURL string
+
+	// source is recorded in the repository for every note of this target.
+	source        string
+	forceFullSync bool
+
+	syncState func(ctx context.Context) (*edam.SyncState, error)
+	syncChunk func(ctx context.Context, afterUSN int32, fullSync bool) (*edam.SyncChunk, error)
+	// listTags is nil for targets that can't list their tags.
+	listTags func(ctx context.Context) ([]*edam.Tag, error)
+
+	// tagNames maps tag GUIDs to names, so that they don't need to be
+	// fetched for every note.
+	tagNames map[string]string
+	// knownVersions are the USNs of the versions of notes that are already
+	// backed up. Versions are only fetched if it is set, see --versions.
+	knownVersions map[string]map[int32]bool
+}
+
+func personalTarget(destDir string) *backupTarget {
+	t := &backupTarget{ns: ns, authToken: client.authToken, destDir: destDir, noteStoreURL: client.noteStoreURL}
+	t.syncState = func(ctx context.Context) (*edam.SyncState, error) {
+		return t.ns.GetSyncState(ctx, t.authToken)
+	}
+	t.syncChunk = func(ctx context.Context, afterUSN int32, fullSync bool) (*edam.SyncChunk, error) {
+		return t.ns.GetFilteredSyncChunk(ctx, t.authToken, afterUSN, maxSyncChunkEntries, syncChunkFilter(!fullSync))
+	}
+	t.listTags = func(ctx context.Context) ([]*edam.Tag, error) {
+		return t.ns.ListTags(ctx, t.authToken)
+	}
+	return t
 }
 
 type command func() error;
 
+// offlineCommands only work on the local backup and don't need to log in.
+var offlineCommands = map[string]bool{
+	"snapshots": true,
+	"prune":     true,
+	"orphans":   true,
+	"tasks":     true,
+}
+
+// renderingCommands write notes in the requested formats and need the
+// passphrases for encrypted sections.
+var renderingCommands = map[string]bool{
+	"":          true,
+	"sync":      true,
+	"export":    true,
+	"duplicate": true,
+}
+
 func (note noteWithResources) dump() {
 	log.Printf("Note: Title = %s", *note.note.Title)
 	log.Printf("      ContentLength = %d", *note.note.ContentLength)
@@ -65,7 +140,7 @@ func (note noteWithResources) dump() {
 		log.Printf("      Resource[%s].GUID = %s", key, *r.GUID)
 		log.Printf("      Resource[%s].Mime = %s", key, *r.Mime)
 		if r.Attributes == nil {
-			log.Printf("      Resource[%s].Attributes IS NULL!")
+			log.Printf("      Resource[%s].Attributes IS NULL!", key)
 		} else {
 			filename := "<nil>"
 			if r.Attributes.FileName != nil {
@@ -74,7 +149,7 @@ func (note noteWithResources) dump() {
 			log.Printf("      Resource[%s].Attributes.FileName = %s", key, filename)
 		}
 		if r.Data == nil {
-			log.Printf("      Resource[%s].Data IS NULL!")
+			log.Printf("      Resource[%s].Data IS NULL!", key)
 		} else {
 			log.Printf("      Resource[%s].Data.Size = %d", key, *r.Data.Size)
 			log.Printf("      Resource[%s].Data.BodyHash = %x", key, r.Data.BodyHash)
@@ -102,6 +177,11 @@ func getCommand() (command, error) {
 			return nil, errors.New("'sync' does not accept parameters")
 		}
 		return sync, nil
+	case "export":
+		if len(args) > 1 {
+			return nil, errors.New("'export' does not accept parameters")
+		}
+		return export, nil
 	case "duplicate":
 		if len(args) > 1 {
 			guids := args[1:]
@@ -111,50 +191,113 @@ func getCommand() (command, error) {
 		} else {
 			return duplicateAll, nil
 		}
+	case "snapshots":
+		return snapshotCommand(args[1:])
+	case "prune":
+		if len(args) > 1 {
+			return nil, errors.New("'prune' does not accept parameters")
+		}
+		return prune, nil
+	case "orphans":
+		if len(args) > 1 {
+			return nil, errors.New("'orphans' does not accept parameters")
+		}
+		return listOrphans, nil
+	case "tasks":
+		if len(args) > 1 {
+			return nil, errors.New("'tasks' does not accept parameters")
+		}
+		return listTasks, nil
+	case "verify":
+		if len(args) > 1 {
+			return nil, errors.New("'verify' does not accept parameters")
+		}
+		return verify, nil
+	case "migrate":
+		if len(args) > 1 {
+			return nil, errors.New("'migrate' does not accept parameters")
+		}
+		return migrate, nil
+	case "restore":
+		if len(args) > 1 {
+			guids := args[1:]
+			return func() error {
+				return restore(guids)
+			}, nil
+		} else {
+			return restoreAll, nil
+		}
 	}
 	return nil, fmt.Errorf("%q is not a valid command.", strings.Join(args, " "))
 }
 
 func main() {
 	flag.Parse()
+	handleSignals()
 
 	if *obfuscateFlag {
 		obfuscateCreds(flag.Arg(0), flag.Arg(1))
 	}
 
-	tokenStore, err := tokenstore.Init()
+	var err error
+	formats, err = parseFormats(*formatFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	env := PRODUCTION
-	if *sandboxFlag {
-		env = SANDBOX
-	}
-	client = newEvernoteClient(env);
-	if err := client.authenticate(tokenStore); err != nil {
-		log.Fatal(err);
+	if err := checkVanishedPolicy(*vanishedFlag); err != nil {
+		log.Fatal(err)
 	}
-	ns, err = client.getNoteStore(context.Background())
-	if err != nil {
-		log.Fatal(err);
+	if renderingCommands[flag.Arg(0)] {
+		if err := loadPassphrases(); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	command, err := getCommand()
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	offline := offlineCommands[flag.Arg(0)] || flag.Arg(0) == "verify" && !*verifyServerFlag
+	if !offline {
+		tokenStore, err := tokenstore.Init()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		client = newEvernoteClient(environment());
+		if err := client.authenticate(tokenStore); err != nil {
+			log.Fatal(err);
+		}
+		ns, err = client.getNoteStore(context.Background())
+		if err != nil {
+			log.Fatal(err);
+		}
+	}
+
 	err = command()
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+func environment() environmentType {
+	if *sandboxFlag {
+		return SANDBOX
+	}
+	return PRODUCTION
+}
+
 func getAllNoteMetadata() ([]*edam.NoteMetadata, error) {
+	return findNotesMetadata(context.Background(), ns, client.authToken, edam.NewNoteFilter())
+}
+
+// findNotesMetadata returns title and USN of all notes matching the filter,
+// oldest first.
+func findNotesMetadata(ctx context.Context, ns edam.NoteStore, authToken string, filter *edam.NoteFilter) ([]*edam.NoteMetadata, error) {
 	res := []*edam.NoteMetadata{}
 
 	start := int32(0);
-	filter := edam.NewNoteFilter()
 	order := int32(edam.NoteSortOrder_CREATED)
 	filter.Order = &order;
 	resultSpec := &edam.NotesMetadataResultSpec{
@@ -162,7 +305,7 @@ func getAllNoteMetadata() ([]*edam.NoteMetadata, error) {
 		IncludeUpdateSequenceNum: boolVal(true),
 	}
 	for {
-		list, err := ns.FindNotesMetadata(context.Background(), client.authToken, filter, start, 100, resultSpec)
+		list, err := ns.FindNotesMetadata(ctx, authToken, filter, start, 100, resultSpec)
 		if err != nil {
 			return res, err
 		}
@@ -190,51 +333,418 @@ func getAllGUIDs() ([]string, error) {
 }
 
 func sync() error {
-	repo, err := repository.Load(*destDirFlag)
+	return syncTo(*destDirFlag)
+}
+
+// export is a sync into --export_dir, typically with a different --layout
+// than the backup.
+func export() error {
+	return syncTo(*exportDirFlag)
+}
+
+func syncTo(destDir string) error {
+	lock, err := lockDestDir(destDir)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := personalTarget(destDir).sync(); err != nil {
+		return err
+	}
+	if *businessFlag {
+		if err := syncBusiness(destDir); err != nil {
+			return err
+		}
+	}
+	if err := syncLinkedNotebooks(destDir); err != nil {
+		return err
+	}
+	if err := unresolvedLinks.write(destDir); err != nil {
+		return err
+	}
+	if *snapshotsFlag {
+		return takeSnapshot(destDir)
+	}
+	return nil
+}
+
+// lockDestDir makes sure that no other duplikator process writes to destDir
+// at the same time. If the previous run died while writing, its leftovers are
+// cleaned up.
+func lockDestDir(destDir string) (*fileutil.Lock, error) {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+	lock, stale, err := fileutil.TryLock(filepath.Join(destDir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		log.Printf("Previous run in %s did not finish, cleaning up", destDir)
+		if err := fileutil.Recover(destDir); err != nil {
+			lock.Unlock()
+			return nil, err
+		}
+	}
+	return lock, nil
+}
+
+func (t *backupTarget) sync() error {
+	ctx := runContext
+	repo, err := repository.Load(t.destDir)
 	if err != nil {
 		return err
 	}
-	syncedRepo := repository.New(*destDirFlag)
-	metadatas, err := getAllNoteMetadata()
+	layout, err := newNoteLayout(t.destDir, repo)
 	if err != nil {
 		return err
 	}
-	for _, md := range metadatas {
-		var e *repository.Entry
-		var ok bool
-		guid := string(md.GUID)
-		if e, ok = repo.Get(guid); ok {
-			if e.UpdateSequenceNum <= int64(*md.UpdateSequenceNum) {
-				log.Printf("Note %q (%s) is up to date", *md.Title, guid)
-				syncedRepo.Add(e)
-				continue
+	if l, ok := layout.(notebookLayout); ok {
+		backfillLinks(repo, l)
+	}
+	state, err := t.syncState(ctx)
+	if err != nil {
+		return err
+	}
+
+	local := repo.SyncState(t.source)
+	fullSync := t.forceFullSync || local.UpdateCount == 0 || int64(state.FullSyncBefore) > local.LastSync
+	if !fullSync && local.UpdateCount >= state.UpdateCount {
+		log.Printf("Repository is up to date (update count %d)", local.UpdateCount)
+		if l, ok := layout.(notebookLayout); ok && *versionsFlag {
+			if err := t.backfillVersions(ctx, repo, l, nil); err != nil {
+				return err
+			}
+		}
+		unresolvedLinks.addRepo(repo)
+		repo.SetSyncState(t.source, repository.SyncState{UpdateCount: local.UpdateCount, LastSync: int64(state.CurrentTime)})
+		return repo.Save()
+	}
+
+	afterUSN := local.UpdateCount
+	if fullSync {
+		log.Printf("Performing full sync")
+		afterUSN = 0
+	} else {
+		log.Printf("Performing incremental sync from USN %d to %d", afterUSN, state.UpdateCount)
+	}
+	chunks, err := collectSyncChunks(func(afterUSN int32) (*edam.SyncChunk, error) {
+		return t.syncChunk(ctx, afterUSN, fullSync)
+	}, afterUSN)
+	if err != nil {
+		return err
+	}
+
+	// Links between notes are rewritten to the notes' HTML files. The files'
+	// locations before the sync tell which notes need new links after it.
+	l, rewriteLinks := layout.(notebookLayout)
+	rewriteLinks = rewriteLinks && (hasFormat("html") || hasFormat("standalone"))
+	var oldHtmlPaths map[string]string
+	if rewriteLinks {
+		oldHtmlPaths = t.htmlPaths(repo, l, chunks, nil)
+	}
+
+	if err := t.applyNotebooksAndTags(repo, layout, chunks); err != nil {
+		return err
+	}
+
+	t.tagNames = make(map[string]string)
+	for _, guid := range repo.TagGUIDs() {
+		tag, _ := repo.Tag(guid)
+		t.tagNames[guid] = tag.Name
+	}
+
+	titles := make(map[string]string)
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		titles[guid] = e.Title
+	}
+	for guid, n := range chunks.notes {
+		titles[guid] = n.GetTitle()
+	}
+
+	seen := make(map[string]bool)
+	var download []string
+	for _, guid := range chunks.noteGUIDs {
+		md := chunks.notes[guid]
+		if !md.GetActive() && !*trashFlag {
+			// Note was moved to the trash
+			continue
+		}
+		seen[guid] = true
+		old, exists := repo.Get(guid)
+		if exists && old.Source == t.source && old.Departed == nil && old.UpdateSequenceNum >= int64(md.GetUpdateSequenceNum()) {
+			if old.NotebookGUID != md.GetNotebookGuid() {
+				// Entry written before notebooks were tracked
+				moved := *old
+				moved.NotebookGUID = md.GetNotebookGuid()
+				log.Printf("Moving note %q (%s) to its notebook", md.GetTitle(), guid)
+				if err := layout.move(old, &moved); err != nil {
+					return err
+				}
+				*old = moved
+				recordPath(t.destDir, layout, old)
+			} else if old.Path == "" {
+				// Entry written before paths were recorded
+				recordPath(t.destDir, layout, old)
+			}
+			log.Printf("Note %q (%s) is up to date", md.GetTitle(), guid)
+			continue
+		}
+		download = append(download, guid)
+	}
+
+	var htmlPaths map[string]string
+	if rewriteLinks {
+		htmlPaths = t.htmlPaths(repo, l, chunks, download)
+	}
+
+	if l, ok := layout.(notebookLayout); ok && *versionsFlag {
+		// Versions are only kept by the notebook layout
+		t.knownVersions = make(map[string]map[int32]bool)
+		for _, guid := range download {
+			if e, ok := repo.Get(guid); ok {
+				t.knownVersions[guid] = knownVersions(l.noteDir(e))
 			}
-			// Existing Note, but needs downloading
-			e = syncedRepo.Add(e)
-		} else {
-	        // New Note, needs downloading
-			e = syncedRepo.GetOrAdd(guid)
 		}
-		log.Printf("Downloading Note %q (%s)", *md.Title, string(md.GUID))
-		n, err := fetchNote(guid, context.Background());
+	}
+
+	fetchers, err := t.fetchers(*parallelismFlag)
+	if err != nil {
+		return err
+	}
+	// Notes are only fetched concurrently, writing them and updating the
+	// repository happens here. The repository is saved regularly, so that
+	// an interrupted sync doesn't need to download these notes again.
+	lastCheckpoint := time.Now()
+	err = fetchNotes(ctx, download, fetchers, func(guid string, n noteWithResources, err error) error {
 		if err != nil {
 			return err
 		}
-		handle(n)
-		e.UpdateSequenceNum = int64(*md.UpdateSequenceNum)
-		e.Title = *md.Title
+		n.notebook, _ = repo.Notebook(n.note.GetNotebookGuid())
+		n.linked = titles
+		n.htmlPaths = htmlPaths
+		if old, exists := repo.Get(guid); exists {
+			moved := *old
+			moved.Title = n.note.GetTitle()
+			moved.NotebookGUID = n.note.GetNotebookGuid()
+			if layout.path(old) != layout.path(withoutPath(&moved)) {
+				// Note was renamed or moved to another notebook. It is
+				// moved before it is overwritten to keep its earlier
+				// versions.
+				if err := layout.move(old, &moved); err != nil {
+					return err
+				}
+			}
+		}
+		if err = layout.save(n); err != nil {
+			return err
+		}
+		t.recordNote(repo, layout, guid, n.note)
+		if time.Since(lastCheckpoint) > checkpointInterval {
+			lastCheckpoint = time.Now()
+			return repo.Save()
+		}
+		return nil
+	})
+	if err != nil {
+		// The sync state is not updated, the next run picks up from here.
+		if saveErr := repo.Save(); saveErr != nil {
+			log.Printf("Can't save repository: %s", saveErr)
+		}
+		return err
+	}
+
+	// Delete notes that are gone from the server
+	var deleted []string
+	reasons := make(map[string]string)
+	if fullSync {
+		for _, guid := range repo.GUIDs() {
+			if e, _ := repo.Get(guid); e.Source == t.source && !seen[guid] {
+				deleted = append(deleted, guid)
+				reasons[guid] = repository.ReasonMissing
+				if md, ok := chunks.notes[guid]; ok && !md.GetActive() {
+					reasons[guid] = repository.ReasonTrashed
+				}
+			}
+		}
+	} else {
+		for _, guid := range chunks.expungedNotes {
+			deleted = append(deleted, guid)
+			reasons[guid] = repository.ReasonExpunged
+		}
+		for _, guid := range chunks.noteGUIDs {
+			if !seen[guid] {
+				deleted = append(deleted, guid)
+				reasons[guid] = repository.ReasonTrashed
+			}
+		}
+	}
+	for _, guid := range deleted {
+		if err := t.removeNote(repo, layout, guid, reasons[guid]); err != nil {
+			return err
+		}
+	}
+
+	if l, ok := layout.(notebookLayout); ok && *versionsFlag {
+		// Notes that were up to date might have been backed up without
+		// their versions.
+		if err := t.backfillVersions(ctx, repo, l, download); err != nil {
+			if saveErr := repo.Save(); saveErr != nil {
+				log.Printf("Can't save repository: %s", saveErr)
+			}
+			return err
+		}
+	}
+
+	if rewriteLinks {
+		// Notes that link to notes that appeared, moved or are gone are
+		// written again from the files in the backup.
+		newHtmlPaths := t.htmlPaths(repo, l, chunks, nil)
+		for _, guid := range notesWithStaleLinks(repo, oldHtmlPaths, htmlPaths, newHtmlPaths, download) {
+			e, _ := repo.Get(guid)
+			log.Printf("Note %q (%s) links to notes that moved, updating it", e.Title, guid)
+			if err := rewriteNoteLinks(l, e, newHtmlPaths); err != nil {
+				log.Printf("Can't update links of note %q: %s", e.Title, err)
+			}
+		}
+	}
+
+	t.removeNotebooksAndTags(repo, chunks, fullSync)
+
+	if err := t.writeTags(ctx, repo, layout); err != nil {
+		return err
+	}
+
+	if l, ok := layout.(notebookLayout); ok && *enexPerNotebookFlag && hasFormat("enex") {
+		if err := writeNotebookEnex(l, repo); err != nil {
+			return err
+		}
+	}
+
+	unresolvedLinks.addRepo(repo)
+	repo.SetSyncState(t.source, repository.SyncState{UpdateCount: chunks.updateCount, LastSync: int64(state.CurrentTime)})
+	return repo.Save()
+}
+
+// recordNote updates the repository entry of a note that was just saved. A
+// note that was kept after it was gone from the server is back.
+func (t *backupTarget) recordNote(repo *repository.Repo, layout noteLayout, guid string, note *edam.Note) {
+	e := repo.GetOrAdd(guid)
+	e.UpdateSequenceNum = int64(note.GetUpdateSequenceNum())
+	e.Title = note.GetTitle()
+	e.Source = t.source
+	e.NotebookGUID = note.GetNotebookGuid()
+	e.Tags = note.TagNames
+	e.TagGUIDs = nil
+	for _, guid := range note.TagGuids {
+		e.TagGUIDs = append(e.TagGUIDs, string(guid))
 	}
+	e.Links = linkedNoteGUIDs(note.GetContent())
+	e.Trashed = !note.GetActive()
+	e.Departed = nil
+	recordPath(t.destDir, layout, e)
+}
 
-	// Delete old files that are not in the new repo
+// applyNotebooksAndTags records new and changed notebooks and tags in the
+// repository. Renamed notebooks are moved on disk.
+func (t *backupTarget) applyNotebooksAndTags(repo *repository.Repo, layout noteLayout, chunks *syncChunks) error {
+	// Renamed notebooks are first moved out of the way, so that notebooks
+	// swapping names don't run into each other's directories.
+	type rename struct{ old, parked, updated *repository.Notebook }
+	var renames []rename
+	for guid, nb := range chunks.notebooks {
+		updated := &repository.Notebook{GUID: guid, Name: nb.GetName(), Stack: nb.GetStack(), Source: t.source}
+		if old, ok := repo.Notebook(guid); ok && (old.Name != updated.Name || old.Stack != updated.Stack) {
+			log.Printf("Notebook %q was renamed to %q", old.Name, updated.Name)
+			parked := &repository.Notebook{GUID: guid, Name: "renaming " + guid}
+			if err := layout.renameNotebook(old, parked); err != nil {
+				return err
+			}
+			renames = append(renames, rename{old, parked, updated})
+		}
+		repo.PutNotebook(updated)
+	}
+	for _, r := range renames {
+		if err := layout.renameNotebook(r.parked, r.updated); err != nil {
+			return err
+		}
+		t.rebasePaths(repo, r.old, r.updated)
+	}
+	for guid, tag := range chunks.tags {
+		repo.PutTag(&repository.Tag{GUID: guid, Name: tag.GetName(), ParentGUID: string(tag.GetParentGuid()), Source: t.source})
+	}
+	return nil
+}
+
+// rebasePaths updates the recorded paths of the notes of a notebook that was
+// moved on disk.
+func (t *backupTarget) rebasePaths(repo *repository.Repo, from, to *repository.Notebook) {
+	rel := func(nb *repository.Notebook) string {
+		r, _ := filepath.Rel(t.destDir, notebookDir(t.destDir, nb))
+		return filepath.ToSlash(r)
+	}
+	oldPrefix, newPrefix := rel(from)+"/", rel(to)+"/"
 	for _, guid := range repo.GUIDs() {
-	    if _, ok := syncedRepo.Get(guid); !ok {
-	    	e, _ := repo.Get(guid);
-			log.Printf("Deleting %q (%s)", e.Title, e.GUID);
-			os.RemoveAll(baseName(e.Title, e.GUID));
-        }
+		e, _ := repo.Get(guid)
+		if e.NotebookGUID == from.GUID && strings.HasPrefix(e.Path, oldPrefix) {
+			e.Path = newPrefix + e.Path[len(oldPrefix):]
+		}
 	}
-	err = syncedRepo.Save()
-	return err
+}
+
+// removeNotebooksAndTags removes notebooks and tags that are gone from the
+// server. This happens after their notes have been removed, as the notes'
+// location depends on their notebook.
+func (t *backupTarget) removeNotebooksAndTags(repo *repository.Repo, chunks *syncChunks, fullSync bool) {
+	if fullSync {
+		for _, guid := range repo.NotebookGUIDs() {
+			nb, _ := repo.Notebook(guid)
+			if _, ok := chunks.notebooks[guid]; !ok && nb.Source == t.source {
+				repo.RemoveNotebook(guid)
+			}
+		}
+		for _, guid := range repo.TagGUIDs() {
+			tag, _ := repo.Tag(guid)
+			if _, ok := chunks.tags[guid]; !ok && tag.Source == t.source {
+				repo.RemoveTag(guid)
+			}
+		}
+	}
+	for _, guid := range chunks.expungedNotebooks {
+		repo.RemoveNotebook(guid)
+	}
+	for _, guid := range chunks.expungedTags {
+		repo.RemoveTag(guid)
+	}
+}
+
+// writeTags refreshes the tags from the server and writes tags.json and the
+// tag views.
+func (t *backupTarget) writeTags(ctx context.Context, repo *repository.Repo, layout noteLayout) error {
+	if t.listTags != nil {
+		tags, err := t.listTags(ctx)
+		if err != nil {
+			return err
+		}
+		for _, guid := range repo.TagGUIDs() {
+			if tag, _ := repo.Tag(guid); tag.Source == t.source {
+				repo.RemoveTag(guid)
+			}
+		}
+		for _, tag := range tags {
+			repo.PutTag(&repository.Tag{GUID: string(tag.GetGUID()), Name: tag.GetName(), ParentGUID: string(tag.GetParentGuid()), Source: t.source})
+		}
+	}
+	refreshTagNames(repo)
+	if err := writeTagsJSON(t.destDir, repo); err != nil {
+		return err
+	}
+	if l, ok := layout.(notebookLayout); ok && *tagViewsFlag {
+		return writeTagViews(l, repo)
+	}
+	return nil
 }
 
 func listAll() error {
@@ -273,31 +783,90 @@ func duplicateAll() error {
 }
 
 func duplicate(guids []string) error {
-	for _, guid := range guids {
-		log.Printf("Downloading Note %s", guid)
-		if note, err := fetchNote(guid, context.Background()); err == nil {
-			err = handle(note)
-			if err != nil {
-				log.Printf("Error while handling %s: %s", *note.note.Title, err)
-			}
-		} else {
+	lock, err := lockDestDir(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	t := personalTarget(*destDirFlag)
+	repo := repository.New(t.destDir)
+	notebooks, err := t.ns.ListNotebooks(context.Background(), t.authToken)
+	if err != nil {
+		return err
+	}
+	for _, nb := range notebooks {
+		repo.PutNotebook(&repository.Notebook{GUID: string(nb.GetGUID()), Name: nb.GetName(), Stack: nb.GetStack()})
+	}
+	layout, err := newNoteLayout(t.destDir, repo)
+	if err != nil {
+		return err
+	}
+	fetchers, err := t.fetchers(*parallelismFlag)
+	if err != nil {
+		return err
+	}
+	return fetchNotes(runContext, guids, fetchers, func(guid string, note noteWithResources, err error) error {
+		if err != nil {
 			log.Printf("Can't download note %s: %s", guid, err)
+			return nil
 		}
-	}
-	return nil
+		note.notebook, _ = repo.Notebook(note.note.GetNotebookGuid())
+		err = layout.save(note)
+		if err != nil {
+			log.Printf("Error while handling %s: %s", *note.note.Title, err)
+		}
+		return nil
+	})
 }
 
-func handle(note noteWithResources) error {
-	return note.save()
+func baseName(destDir, title, guid string) string {
+	filename := makeFilename(title)
+	return filepath.Join(destDir, filename+"-"+guid)
 }
 
-func baseName(title, guid string) string {
-	filename := makeFilename(title)
-	return filepath.Join(*destDirFlag, filename+"-"+guid)
+// reservedDirNames are the directories at the top level of a backup that
+// hold duplikator's own data.
+var reservedDirNames = []string{
+	tagsDirName, enexDirName, linkedDirName, snapshotsDirName, expungedDirName, attachmentsDirName, blobsDirName,
+}
+
+// topLevelDirName returns the directory name of a stack or notebook at the
+// top level of a backup. Names that clash with a reserved directory, also on
+// case insensitive file systems, get a "_" appended. As names that already
+// end in "_" get another one, no two names end up in the same directory.
+func topLevelDirName(name string) string {
+	filename := makeFilename(name)
+	base := strings.TrimRight(filename, "_")
+	for _, reserved := range reservedDirNames {
+		if strings.EqualFold(base, strings.TrimRight(reserved, "_")) {
+			return filename + "_"
+		}
+	}
+	return filename
+}
+
+// notebookDir is the directory the notes of a notebook are stored in.
+func notebookDir(destDir string, nb *repository.Notebook) string {
+	if nb == nil {
+		return destDir
+	}
+	if nb.Stack != "" {
+		return filepath.Join(destDir, topLevelDirName(nb.Stack), makeFilename(nb.Name))
+	}
+	return filepath.Join(destDir, topLevelDirName(nb.Name))
 }
 
 func (note noteWithResources) baseName() string {
-	return baseName(*note.note.Title, string(*note.note.GUID))
+	return baseName(notebookDir(note.destDir, note.notebook), *note.note.Title, string(*note.note.GUID))
+}
+
+func (note noteWithResources) noteFileName(extension string) string {
+	return noteFileName(note.baseName(), note.note.GetTitle(), extension)
+}
+
+func noteFileName(baseName, title, extension string) string {
+	return filepath.Join(baseName, makeFilename(title)+extension)
 }
 
 func (note noteWithResources) attachmentFileName(hash string, relative bool) string {
@@ -308,9 +877,13 @@ func (note noteWithResources) attachmentFileName(hash string, relative bool) str
 		basename = note.baseName() + "/files"
 	}
 
+	return filepath.Join(basename, note.attachmentName(hash))
+}
+
+func (note noteWithResources) attachmentName(hash string) string {
 	attachmentName := ""
 	r := note.resources[hash]
-	if r.Attributes.FileName != nil {
+	if r.Attributes != nil && r.Attributes.FileName != nil {
 		attachmentName = *r.Attributes.FileName
 	} else {
 		// No filename given, lets create one
@@ -322,59 +895,114 @@ func (note noteWithResources) attachmentFileName(hash string, relative bool) str
 			attachmentName = attachmentName + exts[0]
 		}
 	}
-	return filepath.Join(basename, attachmentName)
+	return attachmentName
 }
 
+// save writes the note into a temporary directory that then replaces the
+// note's directory, so that a crash never leaves a half written note behind.
+// Earlier versions of the note are kept.
 func (note noteWithResources) save() error {
-	os.RemoveAll(note.baseName());
-
-	// Save html
-
-	filename := note.baseName() + "/" + makeFilename(note.note.GetTitle()) + ".html"
-	err := os.MkdirAll(path.Dir(filename), 0755)
+	dir, err := fileutil.TempDir(note.baseName())
 	if err != nil {
 		return err
 	}
-	f, err := os.Create(filename)
-	if err != nil {
+	defer os.RemoveAll(dir)
+
+	if err := note.writeTo(dir); err != nil {
 		return err
 	}
-	note.convertToHtml(f)
-	err = f.Close()
+
+	versionsDir := filepath.Join(note.baseName(), versionsDirName)
+	if _, err := os.Stat(versionsDir); err == nil {
+		if err := fileutil.LinkTree(versionsDir, filepath.Join(dir, versionsDirName)); err != nil {
+			return err
+		}
+	}
+	for _, v := range note.versions {
+		versionDir := filepath.Join(dir, versionsDirName, fmt.Sprint(v.note.GetUpdateSequenceNum()))
+		if err := os.MkdirAll(versionDir, 0755); err != nil {
+			return err
+		}
+		if err := v.writeTo(versionDir); err != nil {
+			return err
+		}
+	}
+
+	return fileutil.ReplaceDir(dir, note.baseName())
+}
+
+// writeTo writes the note in all requested formats, its metadata and its
+// attachments to dir.
+func (note noteWithResources) writeTo(dir string) error {
+	// Save note in all requested formats
+	for _, format := range formats {
+		filename := noteFileName(dir, note.note.GetTitle(), noteFormats[format].extension)
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		err = noteFormats[format].convert(note, f)
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := note.writeMetadata(filepath.Join(dir, noteMetadataFileName))
 	if err != nil {
 		return err
 	}
 
 	// Save attachments
 	for hash, res := range note.resources {
-		filename = note.attachmentFileName(hash, false)
+		filename := filepath.Join(dir, note.attachmentFileName(hash, true))
 		err = os.MkdirAll(path.Dir(filename), 0755)
 		if err != nil {
 			return err
 		}
-		err = ioutil.WriteFile(filename, res.Data.Body, 0644)
+		err = writeAttachment(note.destDir, filename, hash, res.Data.Body)
+		if err != nil {
+			return err
+		}
+		err = writeRecognition(res, filename)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func (note noteWithResources) convertToHtml(w io.Writer) {
-	w.Write([]byte(`<!doctype html>
-<html>
-<head>
-</head>
-`))
-	z := html.NewTokenizer(strings.NewReader(*note.note.Content))
+func (note noteWithResources) convertToHtml(w io.Writer) error {
+	return note.writeHtml(w, false)
+}
+
+// writeHtml converts a note to HTML. If standalone is set, attachments are
+// embedded instead of linked.
+func (note noteWithResources) writeHtml(w io.Writer, standalone bool) error {
+	note.writeHtmlHead(w)
+	if err := note.writeEnml(w, html.NewTokenizer(strings.NewReader(*note.note.Content)), standalone); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("</html>"))
+	return err
+}
+
+// writeEnml converts the ENML read from z to HTML.
+func (note noteWithResources) writeEnml(w io.Writer, z *html.Tokenizer, standalone bool) error {
 	for {
 		if z.Next() == html.ErrorToken {
 			if z.Err() == io.EOF {
 				// Returning io.EOF indicates success.
 				break;
 			}
-			log.Fatal(z.Err())
+			return z.Err()
 		}
 		tok := z.Token();
 		if tok.Type == html.DoctypeToken || tok.Type == html.CommentToken {
@@ -387,7 +1015,7 @@ func (note noteWithResources) convertToHtml(w io.Writer) {
 			} else if tok.Type == html.EndTagToken {
 				w.Write([]byte("</body>"))
 			} else {
-				log.Fatalf("Can't happen! token = %s", tok)
+				return fmt.Errorf("Can't happen! token = %s", tok)
 			}
 		case "en-media":
 			if tok.Type == html.EndTagToken {
@@ -397,6 +1025,10 @@ func (note noteWithResources) convertToHtml(w io.Writer) {
 			t, _ := findAttribute(tok, "type");
 			h, _ := findAttribute(tok, "hash");
 			filename := note.attachmentFileName(h, true)
+			src := filename
+			if uri, ok := note.dataURI(h); ok && standalone && (isImage(t) || *embedAttachmentsFlag) {
+				src = uri
+			}
 			if isImage(t) {
 				height := ""
 				width := ""
@@ -406,19 +1038,68 @@ func (note noteWithResources) convertToHtml(w io.Writer) {
 				if h, ok := findAttribute(tok, "height"); ok {
 					height = fmt.Sprintf("height=\"%s\"", h)
 				}
-				w.Write([]byte(fmt.Sprintf("<img src=\"%s\" %s %s>", filename, width, height)))
+				w.Write([]byte(fmt.Sprintf("<img src=\"%s\" %s %s>", src, width, height)))
 			} else {
-				displayName := note.resources[h].Attributes.FileName
-				if displayName == nil {
-					displayName = &filename
+				displayName := filename
+				if r, ok := note.resources[h]; ok && r.Attributes != nil && r.Attributes.FileName != nil {
+					displayName = *r.Attributes.FileName
 				}
-				w.Write([]byte(fmt.Sprintf("<a href=\"%s\">%s</a>", filename, *displayName)))
+				download := ""
+				if src != filename {
+					download = fmt.Sprintf(" download=\"%s\"", html.EscapeString(path.Base(filename)))
+				}
+				w.Write([]byte(fmt.Sprintf("<a href=\"%s\"%s>%s</a>", src, download, html.EscapeString(displayName))))
+			}
+		case "a":
+			if tok.Type == html.StartTagToken {
+				for i, a := range tok.Attr {
+					if a.Key != "href" {
+						continue
+					}
+					if link, ok := note.localLink(a.Val); ok {
+						tok.Attr[i].Val = link
+					}
+				}
+			}
+			w.Write([]byte(tok.String()))
+		case "en-todo":
+			if tok.Type == html.EndTagToken {
+				break
+			}
+			checked := ""
+			if c, _ := findAttribute(tok, "checked"); c == "true" {
+				checked = " checked"
+			}
+			w.Write([]byte(fmt.Sprintf("<input type=\"checkbox\" disabled%s>", checked)))
+		case "en-crypt":
+			if tok.Type != html.StartTagToken || len(passphrases) == 0 {
+				w.Write([]byte(tok.String()))
+				break
+			}
+			// Collect the encrypted content up to the end tag
+			attrs := make(map[string]string)
+			for _, a := range tok.Attr {
+				attrs[a.Key] = a.Val
+			}
+			raw := tok.String()
+			content := ""
+			for z.Next() == html.TextToken {
+				text := z.Token()
+				raw += text.String()
+				content += text.Data
+			}
+			if plain, ok := decryptSection(attrs, content); ok {
+				if err := note.writeDecryptedHtml(w, plain, standalone); err != nil {
+					return err
+				}
+			} else {
+				w.Write([]byte(raw + z.Token().String()))
 			}
 		default:
 			w.Write([]byte(tok.String()))
 		}
 	}
-	w.Write([]byte("</html>"))
+	return nil
 }
 
 func isImage(mimetype string) bool {
@@ -449,31 +1130,67 @@ func makeFilename(raw string) string {
 	return res
 }
 
-func fetchNote(guid string, ctx context.Context) (noteWithResources, error) {
+func (t *backupTarget) fetchNote(guid string, ctx context.Context) (noteWithResources, error) {
 	var err error
 
-	note := noteWithResources{}
+	note := noteWithResources{destDir: t.destDir}
+	// With a blob store, resource data is only downloaded if it isn't
+	// there yet.
+	store := blobs(t.destDir)
 	nrs := &edam.NoteResultSpec{
 		IncludeContent:                boolVal(true),
-		IncludeResourcesData:          boolVal(true),
+		IncludeResourcesData:          boolVal(store == ""),
+		IncludeResourcesRecognition:   boolVal(true),
 		IncludeResourcesAlternateData: boolVal(true),
 	}
-	note.note, err = ns.GetNoteWithResultSpec(ctx, client.authToken, edam.GUID(guid), nrs)
+	note.note, err = t.ns.GetNoteWithResultSpec(ctx, t.authToken, edam.GUID(guid), nrs)
 	if err != nil {
 		return note, err
 	}
+	if len(note.note.TagGuids) > 0 && len(note.note.TagNames) == 0 {
+		note.note.TagNames, err = t.resolveTagNames(ctx, note.note)
+		if err != nil {
+			return note, err
+		}
+	}
 
 	if len(note.note.Resources) > 0 {
 		note.resources = make(map[string]*edam.Resource)
 		for _, res := range note.note.Resources {
-			if r, err := ns.GetResource(ctx, client.authToken, *res.GUID, /* withData= */ true, /* withRecognition= */ true, /* withAttributes= */ true, /* withAlternateData */ true); err == nil {
+			if store != "" {
+				if err := store.loadData(ctx, t, res); err != nil {
+					return note, err
+				}
+				note.resources[hex.EncodeToString(res.Data.BodyHash)] = res
+				continue
+			}
+			if r, err := t.ns.GetResource(ctx, t.authToken, *res.GUID, /* withData= */ true, /* withRecognition= */ true, /* withAttributes= */ true, /* withAlternateData */ true); err == nil {
+				if r.Recognition != nil {
+					res.Recognition = r.Recognition
+				}
 				note.resources[hex.EncodeToString(r.Data.BodyHash)] = res
 			} else {
 				return note, err
 			}
 		}
 	}
-	return note, nil
+	if t.knownVersions != nil {
+		note.versions, err = t.fetchVersions(ctx, guid)
+	}
+	return note, err
+}
+
+func (t *backupTarget) resolveTagNames(ctx context.Context, note *edam.Note) ([]string, error) {
+	res := []string{}
+	for _, guid := range note.TagGuids {
+		name, ok := t.tagNames[string(guid)]
+		if !ok {
+			// Unknown tag, let the server resolve them
+			return t.ns.GetNoteTagNames(ctx, t.authToken, note.GetGUID())
+		}
+		res = append(res, name)
+	}
+	return res, nil
 }
 
 func boolVal(val bool) *bool {
@@ -20,24 +20,100 @@
 package main
 
 import (
+	"bytes"
+	"strings"
 	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
 )
 
 func TestMakeFilename(t *testing.T) {
 	tests := []struct {
-		name string
-		raw        string
+		name     string
+		raw      string
 		expected string
 	}{
-		{"clean name","hello", "hello"},
+		{"clean name", "hello", "hello"},
 		{"needs cleaning", "he\\llo", "he_llo"},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			got := makeFilename(test.raw);
+			got := makeFilename(test.raw)
 			if got != test.expected {
-				t.Errorf("Expected %q, got %q", test.expected, got);
+				t.Errorf("Expected %q, got %q", test.expected, got)
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestNotebookDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		notebook *repository.Notebook
+		expected string
+	}{
+		{"no notebook", nil, "/backup"},
+		{"notebook", &repository.Notebook{Name: "Recipes"}, "/backup/Recipes"},
+		{"stack", &repository.Notebook{Name: "Recipes", Stack: "Home/Kitchen"}, "/backup/Home_Kitchen/Recipes"},
+		{"reserved notebook", &repository.Notebook{Name: "_tags"}, "/backup/_tags_"},
+		{"reserved notebook other case", &repository.Notebook{Name: "_Linked"}, "/backup/_Linked_"},
+		{"escaped notebook", &repository.Notebook{Name: "_tags_"}, "/backup/_tags__"},
+		{"reserved stack", &repository.Notebook{Name: "_enex", Stack: "blobs"}, "/backup/blobs_/_enex"},
+		{"similar name", &repository.Notebook{Name: "_tagsx"}, "/backup/_tagsx"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := notebookDir("/backup", test.notebook)
+			if got != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestHtmlAttachmentWithoutAttributes(t *testing.T) {
+	content := enmlHeader + `<en-note><en-media type="application/pdf" hash="0123"/></en-note>`
+	title := "Hello"
+	guid := edam.GUID("note")
+	resGUID := edam.GUID("r1")
+	mimeType := "application/pdf"
+	note := noteWithResources{
+		note:      &edam.Note{GUID: &guid, Title: &title, Content: &content},
+		destDir:   "/backup",
+		resources: map[string]*edam.Resource{"0123": {GUID: &resGUID, Mime: &mimeType}},
+	}
+	var b bytes.Buffer
+	if err := note.convertToHtml(&b); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<a href="files/r1.pdf">files/r1.pdf</a>`; !strings.Contains(b.String(), expected) {
+		t.Errorf("Expected %s in %s", expected, b.String())
+	}
+}
+
+func TestDecryptedHtmlIsSanitized(t *testing.T) {
+	title := "Hello"
+	guid := edam.GUID("note")
+	note := noteWithResources{note: &edam.Note{GUID: &guid, Title: &title}, destDir: "/backup"}
+	plain := `<div onclick="steal()" id="x">Hi <a href=" java	script:alert(1)">there</a></div></div></div>` +
+		`<script>alert(1)</script><iframe src="https://example.com"></iframe><en-todo checked="true"/>done`
+	var b bytes.Buffer
+	if err := note.writeDecryptedHtml(&b, plain, false); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, s := range []string{"onclick", "id=", "script", "iframe"} {
+		if strings.Contains(got, s) {
+			t.Errorf("Expected no %q in %s", s, got)
+		}
+	}
+	for _, s := range []string{"<div>Hi <a>there</a></div>", `<input type="checkbox" disabled checked>`, "done"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("Expected %q in %s", s, got)
+		}
+	}
+	if opened, closed := strings.Count(got, "<div"), strings.Count(got, "</div>"); opened != closed {
+		t.Errorf("Expected balanced divs, got %d opened and %d closed in %s", opened, closed, got)
+	}
+}
@@ -14,7 +14,7 @@ import (
         "strconv"
         "strings"
         "github.com/apache/thrift/lib/go/thrift"
-        "github.com/asig/evernote-backup/edam"
+        "github.com/asig/duplikator/edam"
 )
 
 
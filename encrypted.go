@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/asig/duplikator/crypt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/term"
+)
+
+var (
+	passphraseFlag     = flag.String("passphrase", "", "Passphrase to decrypt encrypted sections of notes with")
+	passphraseFileFlag = flag.String("passphrase_file", "", "File with passphrases to decrypt encrypted sections of notes with, one per line")
+	askPassphraseFlag  = flag.Bool("ask_passphrase", false, "Ask for a passphrase to decrypt encrypted sections of notes with")
+
+	// passphrases are tried in order on every encrypted section. Sections
+	// that none of them decrypts are left encrypted.
+	passphrases []string
+)
+
+func loadPassphrases() error {
+	if *passphraseFlag != "" {
+		passphrases = append(passphrases, *passphraseFlag)
+	}
+	if *passphraseFileFlag != "" {
+		b, err := ioutil.ReadFile(*passphraseFileFlag)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				passphrases = append(passphrases, line)
+			}
+		}
+	}
+	if *askPassphraseFlag {
+		p, err := askPassphrase()
+		if err != nil {
+			return err
+		}
+		passphrases = append(passphrases, p)
+	}
+	return nil
+}
+
+// askPassphrase reads a passphrase from the terminal without echoing it.
+func askPassphrase() (string, error) {
+	fmt.Fprint(os.Stderr, "Passphrase for encrypted sections: ")
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// decryptSection decrypts the content of an en-crypt element with the
+// given attributes. It returns false if no passphrase fits.
+func decryptSection(attrs map[string]string, content string) (string, bool) {
+	length, _ := strconv.Atoi(attrs["length"])
+	for _, p := range passphrases {
+		if plain, err := crypt.Decrypt(attrs["cipher"], length, content, p); err == nil {
+			return plain, true
+		}
+	}
+	return "", false
+}
+
+// prohibitedElements are the elements ENML doesn't allow. They are removed
+// from decrypted sections, which the server never validated.
+var prohibitedElements = map[string]bool{
+	"applet": true, "base": true, "basefont": true, "bgsound": true, "blink": true,
+	"body": true, "button": true, "dir": true, "embed": true, "fieldset": true,
+	"form": true, "frame": true, "frameset": true, "head": true, "html": true,
+	"iframe": true, "ilayer": true, "input": true, "isindex": true, "label": true,
+	"layer": true, "legend": true, "link": true, "marquee": true, "menu": true,
+	"meta": true, "noframes": true, "noscript": true, "object": true, "optgroup": true,
+	"option": true, "param": true, "plaintext": true, "script": true, "select": true,
+	"style": true, "textarea": true, "xml": true,
+}
+
+// writeDecryptedHtml renders a decrypted section like the rest of the note,
+// marking it as decrypted. The section is parsed and cleaned first, so that
+// it can neither contain active content nor close elements around it.
+func (note noteWithResources) writeDecryptedHtml(w io.Writer, plain string, standalone bool) error {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(plain), context)
+	if err != nil {
+		return err
+	}
+	var clean bytes.Buffer
+	for _, n := range nodes {
+		if sanitizeEnml(n) {
+			if err := html.Render(&clean, n); err != nil {
+				return err
+			}
+		}
+	}
+	w.Write([]byte(`<div class="en-crypt-decrypted" style="border: 1px dashed #999; padding: 4px">` +
+		`<div style="color: #999; font-size: smaller">Decrypted section</div>`))
+	if err := note.writeEnml(w, html.NewTokenizer(&clean), standalone); err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("</div>"))
+	return err
+}
+
+// sanitizeEnml removes the elements and attributes ENML doesn't allow from
+// the tree rooted at n. It returns false if n itself has to be removed.
+func sanitizeEnml(n *html.Node) bool {
+	switch n.Type {
+	case html.TextNode:
+		return true
+	case html.ElementNode:
+		if prohibitedElements[n.Data] {
+			return false
+		}
+	default:
+		return false
+	}
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if key == "id" || key == "class" || strings.HasPrefix(key, "on") || isScriptURL(a.Val) {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if !sanitizeEnml(c) {
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+	return true
+}
+
+// isScriptURL returns whether val is a URL that runs a script when
+// followed. Browsers ignore whitespace and control characters in the scheme.
+func isScriptURL(val string) bool {
+	val = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, val))
+	return strings.HasPrefix(val, "javascript:") || strings.HasPrefix(val, "vbscript:")
+}
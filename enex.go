@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+// Structures of the Evernote export format, see
+// http://xml.evernote.com/pub/evernote-export3.dtd
+
+const (
+	// enexDirName is the directory below the backup that holds the
+	// per-notebook exports.
+	enexDirName = "_enex"
+
+	enexTimeFormat = "20060102T150405Z"
+	enexHeader     = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+`
+)
+
+type enexExport struct {
+	XMLName     xml.Name    `xml:"en-export"`
+	ExportDate  string      `xml:"export-date,attr"`
+	Application string      `xml:"application,attr"`
+	Version     string      `xml:"version,attr"`
+	Notes       []*enexNote `xml:"note"`
+}
+
+type enexNote struct {
+	Title      string              `xml:"title"`
+	Content    enexContent         `xml:"content"`
+	Created    string              `xml:"created,omitempty"`
+	Updated    string              `xml:"updated,omitempty"`
+	Tags       []string            `xml:"tag"`
+	Attributes *enexNoteAttributes `xml:"note-attributes"`
+	Resources  []*enexResource     `xml:"resource"`
+}
+
+type enexContent struct {
+	Content string `xml:",cdata"`
+}
+
+type enexNoteAttributes struct {
+	SubjectDate       string                `xml:"subject-date,omitempty"`
+	Latitude          *float64              `xml:"latitude,omitempty"`
+	Longitude         *float64              `xml:"longitude,omitempty"`
+	Altitude          *float64              `xml:"altitude,omitempty"`
+	Author            string                `xml:"author,omitempty"`
+	Source            string                `xml:"source,omitempty"`
+	SourceURL         string                `xml:"source-url,omitempty"`
+	SourceApplication string                `xml:"source-application,omitempty"`
+	ReminderOrder     *int64                `xml:"reminder-order,omitempty"`
+	ReminderTime      string                `xml:"reminder-time,omitempty"`
+	ReminderDoneTime  string                `xml:"reminder-done-time,omitempty"`
+	PlaceName         string                `xml:"place-name,omitempty"`
+	ContentClass      string                `xml:"content-class,omitempty"`
+	ApplicationData   []enexApplicationData `xml:"application-data"`
+}
+
+type enexApplicationData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type enexResource struct {
+	Data          enexData                `xml:"data"`
+	Mime          string                  `xml:"mime"`
+	Width         *int16                  `xml:"width,omitempty"`
+	Height        *int16                  `xml:"height,omitempty"`
+	Duration      *int16                  `xml:"duration,omitempty"`
+	Recognition   *enexContent            `xml:"recognition,omitempty"`
+	Attributes    *enexResourceAttributes `xml:"resource-attributes"`
+	AlternateData *enexData               `xml:"alternate-data,omitempty"`
+}
+
+type enexData struct {
+	Encoding string `xml:"encoding,attr"`
+	Body     string `xml:",chardata"`
+}
+
+type enexResourceAttributes struct {
+	SourceURL       string                `xml:"source-url,omitempty"`
+	Timestamp       string                `xml:"timestamp,omitempty"`
+	Latitude        *float64              `xml:"latitude,omitempty"`
+	Longitude       *float64              `xml:"longitude,omitempty"`
+	Altitude        *float64              `xml:"altitude,omitempty"`
+	CameraMake      string                `xml:"camera-make,omitempty"`
+	CameraModel     string                `xml:"camera-model,omitempty"`
+	RecoType        string                `xml:"reco-type,omitempty"`
+	FileName        string                `xml:"file-name,omitempty"`
+	Attachment      *bool                 `xml:"attachment,omitempty"`
+	ApplicationData []enexApplicationData `xml:"application-data"`
+}
+
+func newEnexExport() *enexExport {
+	return &enexExport{
+		ExportDate:  time.Now().UTC().Format(enexTimeFormat),
+		Application: "Duplikator",
+		Version:     "1.0",
+	}
+}
+
+func (e *enexExport) write(w io.Writer) error {
+	if _, err := io.WriteString(w, enexHeader); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func readEnex(filename string) (*enexExport, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res := &enexExport{}
+	err = xml.NewDecoder(f).Decode(res)
+	return res, err
+}
+
+// writeNotebookEnex merges the .enex files of all notes in the repository
+// into one .enex file per notebook.
+func writeNotebookEnex(layout notebookLayout, repo *repository.Repo) error {
+	exports := make(map[string]*enexExport)
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		filename := noteFileName(layout.noteDir(e), e.Title, ".enex")
+		noteExport, err := readEnex(filename)
+		if err != nil {
+			log.Printf("Can't read %s, skipping note in notebook export: %s", filename, err)
+			continue
+		}
+		export, ok := exports[e.NotebookGUID]
+		if !ok {
+			export = newEnexExport()
+			exports[e.NotebookGUID] = export
+		}
+		export.Notes = append(export.Notes, noteExport.Notes...)
+	}
+
+	dir := filepath.Join(layout.destDir, enexDirName)
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for notebookGUID, export := range exports {
+		// Notebooks in different stacks or accounts can have the same
+		// name, the GUID keeps their exports apart.
+		filename := filepath.Join(dir, notebookGUID+".enex")
+		if nb, ok := repo.Notebook(notebookGUID); ok {
+			filename = baseName(dir, nb.Name, notebookGUID) + ".enex"
+		}
+		log.Printf("Writing notebook export %s", filename)
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		err = export.write(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (note noteWithResources) convertToEnex(w io.Writer) error {
+	export := newEnexExport()
+	export.Notes = append(export.Notes, note.toEnex())
+	return export.write(w)
+}
+
+func (note noteWithResources) toEnex() *enexNote {
+	n := note.note
+	res := &enexNote{
+		Title:   n.GetTitle(),
+		Content: enexContent{n.GetContent()},
+		Created: enexTime(n.Created),
+		Updated: enexTime(n.Updated),
+		Tags:    n.TagNames,
+	}
+	if a := n.Attributes; a != nil {
+		res.Attributes = &enexNoteAttributes{
+			SubjectDate:       enexTime(a.SubjectDate),
+			Latitude:          a.Latitude,
+			Longitude:         a.Longitude,
+			Altitude:          a.Altitude,
+			Author:            a.GetAuthor(),
+			Source:            a.GetSource(),
+			SourceURL:         a.GetSourceURL(),
+			SourceApplication: a.GetSourceApplication(),
+			ReminderOrder:     a.ReminderOrder,
+			ReminderTime:      enexTime(a.ReminderTime),
+			ReminderDoneTime:  enexTime(a.ReminderDoneTime),
+			PlaceName:         a.GetPlaceName(),
+			ContentClass:      a.GetContentClass(),
+			ApplicationData:   enexApplicationDataOf(a.ApplicationData),
+		}
+	}
+	for _, r := range n.Resources {
+		res.Resources = append(res.Resources, enexResourceOf(r))
+	}
+	return res
+}
+
+func enexResourceOf(r *edam.Resource) *enexResource {
+	res := &enexResource{
+		Mime:     r.GetMime(),
+		Width:    r.Width,
+		Height:   r.Height,
+		Duration: r.Duration,
+	}
+	if r.Data != nil {
+		res.Data = enexData{"base64", base64.StdEncoding.EncodeToString(r.Data.Body)}
+	}
+	if r.Recognition != nil && len(r.Recognition.Body) > 0 {
+		res.Recognition = &enexContent{string(r.Recognition.Body)}
+	}
+	if r.AlternateData != nil && len(r.AlternateData.Body) > 0 {
+		res.AlternateData = &enexData{"base64", base64.StdEncoding.EncodeToString(r.AlternateData.Body)}
+	}
+	if a := r.Attributes; a != nil {
+		res.Attributes = &enexResourceAttributes{
+			SourceURL:       a.GetSourceURL(),
+			Timestamp:       enexTime(a.Timestamp),
+			Latitude:        a.Latitude,
+			Longitude:       a.Longitude,
+			Altitude:        a.Altitude,
+			CameraMake:      a.GetCameraMake(),
+			CameraModel:     a.GetCameraModel(),
+			RecoType:        a.GetRecoType(),
+			FileName:        a.GetFileName(),
+			Attachment:      a.Attachment,
+			ApplicationData: enexApplicationDataOf(a.ApplicationData),
+		}
+	}
+	return res
+}
+
+func enexApplicationDataOf(m *edam.LazyMap) []enexApplicationData {
+	if m == nil {
+		return nil
+	}
+	keys := []string{}
+	for k := range m.FullMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := []enexApplicationData{}
+	for _, k := range keys {
+		res = append(res, enexApplicationData{k, m.FullMap[k]})
+	}
+	return res
+}
+
+func enexTime(ts *edam.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return timestampToTime(*ts).UTC().Format(enexTimeFormat)
+}
+
+func timestampToTime(ts edam.Timestamp) time.Time {
+	return time.Unix(0, int64(ts)*int64(time.Millisecond))
+}
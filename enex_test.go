@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestConvertToEnex(t *testing.T) {
+	title := "Hello"
+	content := `<?xml version="1.0" encoding="UTF-8"?><en-note><div>Hi ]]> there</div></en-note>`
+	created := edam.Timestamp(1564660800000) // 2019-08-01 12:00:00 UTC
+	mimeType := "text/plain"
+	note := noteWithResources{
+		note: &edam.Note{
+			Title:    &title,
+			Content:  &content,
+			Created:  &created,
+			TagNames: []string{"a", "b"},
+			Resources: []*edam.Resource{
+				{Mime: &mimeType, Data: &edam.Data{Body: []byte("data")}},
+			},
+		},
+	}
+
+	b := bytes.Buffer{}
+	if err := note.convertToEnex(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), enexHeader) {
+		t.Errorf("Missing ENEX header")
+	}
+
+	export := enexExport{}
+	if err := xml.Unmarshal(b.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Notes) != 1 {
+		t.Fatalf("Expected 1 note, got %d", len(export.Notes))
+	}
+	n := export.Notes[0]
+	if n.Content.Content != content {
+		t.Errorf("Expected content %q, got %q", content, n.Content.Content)
+	}
+	if n.Created != "20190801T120000Z" {
+		t.Errorf("Expected created 20190801T120000Z, got %q", n.Created)
+	}
+	if len(n.Tags) != 2 {
+		t.Errorf("Expected 2 tags, got %v", n.Tags)
+	}
+	if len(n.Resources) != 1 || n.Resources[0].Data.Body != "ZGF0YQ==" {
+		t.Errorf("Unexpected resources %+v", n.Resources)
+	}
+}
+
+func TestWriteNotebookEnex(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "enex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	l := notebookLayout{destDir, repo}
+	repo.PutNotebook(&repository.Notebook{GUID: "nb1", Name: "Ideas", Stack: "Work"})
+	repo.PutNotebook(&repository.Notebook{GUID: "nb2", Name: "Ideas", Stack: "Home"})
+	for _, nb := range []string{"nb1", "nb2"} {
+		e := repo.Add(&repository.Entry{GUID: "g" + nb, Title: "Note", NotebookGUID: nb})
+		dir := l.noteDir(e)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		content := enmlHeader + "<en-note>Hi</en-note>"
+		note := noteWithResources{note: &edam.Note{Title: &e.Title, Content: &content}}
+		f, err := os.Create(noteFileName(dir, e.Title, ".enex"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := note.convertToEnex(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	if err := writeNotebookEnex(l, repo); err != nil {
+		t.Fatal(err)
+	}
+	for _, nb := range []string{"nb1", "nb2"} {
+		export, err := readEnex(baseName(filepath.Join(destDir, enexDirName), "Ideas", nb) + ".enex")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(export.Notes) != 1 {
+			t.Errorf("Expected 1 note in notebook %s, got %d", nb, len(export.Notes))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// enmlHeader starts every ENML document.
+const enmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+`
+
+// enmlNode is a node of a parsed ENML document. Text nodes have an empty
+// name.
+type enmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*enmlNode
+}
+
+// parseEnml parses ENML content and returns its en-note element. ENML is
+// XML, so unlike the HTML parser, this keeps self-closing elements like
+// en-media and en-todo empty.
+func parseEnml(content string) (*enmlNode, error) {
+	d := xml.NewDecoder(strings.NewReader(content))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	root := &enmlNode{}
+	stack := []*enmlNode{root}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &enmlNode{name: strings.ToLower(tok.Name.Local), attrs: make(map[string]string)}
+			for _, a := range tok.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			top.children = append(top.children, &enmlNode{text: string(tok)})
+		}
+	}
+	if n := root.find("en-note"); n != nil {
+		return n, nil
+	}
+	return nil, errors.New("no en-note element found")
+}
+
+// find returns the first element with the given name, in document order.
+func (n *enmlNode) find(name string) *enmlNode {
+	if n.name == name {
+		return n
+	}
+	for _, c := range n.children {
+		if res := c.find(name); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+// textContent returns the concatenated text of the node and its children.
+func (n *enmlNode) textContent() string {
+	if n.name == "" {
+		return n.text
+	}
+	res := ""
+	for _, c := range n.children {
+		res += c.textContent()
+	}
+	return res
+}
+
+func (n *enmlNode) isWhitespace() bool {
+	return n.name == "" && strings.TrimSpace(n.text) == ""
+}
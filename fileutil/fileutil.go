@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package fileutil writes files and directories so that a crash never leaves
+// a half written file behind: content is written to a temporary file, synced
+// to disk and then renamed into place.
+package fileutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// TempSuffix is added to files and directories while they are written.
+	TempSuffix = ".duplikator-tmp"
+	// oldSuffix is added to a directory while it is replaced.
+	oldSuffix = ".duplikator-old"
+
+	// lockGracePeriod is how long a lock file without a pid counts as held,
+	// as its process might not have written the pid yet.
+	lockGracePeriod = 10 * time.Second
+)
+
+// WriteFile atomically replaces filename with data.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	tmp := filename + TempSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return SyncDir(filepath.Dir(filename))
+}
+
+// TempDir returns an empty directory that can be filled and then moved to
+// dest with ReplaceDir.
+func TempDir(dest string) (string, error) {
+	tmp := dest + TempSuffix
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", err
+	}
+	return tmp, os.MkdirAll(tmp, 0755)
+}
+
+// ReplaceDir replaces dest with the directory tmp. The files in tmp must
+// already be synced. The old content of dest is only removed once tmp is in
+// place; if the process dies in between, Recover restores it.
+func ReplaceDir(tmp, dest string) error {
+	if err := SyncDir(tmp); err != nil {
+		return err
+	}
+	old := dest + oldSuffix
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dest, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Rename(old, dest)
+		return err
+	}
+	if err := SyncDir(filepath.Dir(dest)); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// SyncDir flushes a directory, so that renames in it are persisted.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		// Some platforms and file systems can't sync directories.
+		return err
+	}
+	return nil
+}
+
+// Recover cleans up after writes below root that were interrupted: temporary
+// files are removed and directories that were being replaced are restored.
+func Recover(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(path, TempSuffix):
+			if info.IsDir() {
+				return skipAfter(os.RemoveAll(path))
+			}
+			return os.Remove(path)
+		case info.IsDir() && strings.HasSuffix(path, oldSuffix):
+			dest := strings.TrimSuffix(path, oldSuffix)
+			if _, err := os.Stat(dest); os.IsNotExist(err) {
+				return skipAfter(os.Rename(path, dest))
+			}
+			return skipAfter(os.RemoveAll(path))
+		}
+		return nil
+	})
+}
+
+func skipAfter(err error) error {
+	if err != nil {
+		return err
+	}
+	return filepath.SkipDir
+}
+
+// Lock is a lock file that keeps several processes from writing to the same
+// directory.
+type Lock struct {
+	filename string
+}
+
+// ErrLocked is returned by TryLock if another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// TryLock creates the lock file. A lock file left behind by a process that
+// no longer runs is taken over; stale is set in this case, as the previous
+// process might have been interrupted while writing. The same goes for lock
+// files without a valid pid that are older than lockGracePeriod, e.g. because
+// the process died right after creating it.
+func TryLock(filename string) (l *Lock, stale bool, err error) {
+	for {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(filename)
+				return nil, false, err
+			}
+			return &Lock{filename}, stale, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		held := false
+		if err == nil {
+			if pid, convErr := strconv.Atoi(strings.TrimSpace(string(b))); convErr == nil {
+				held = processAlive(pid)
+			} else if fi, statErr := os.Stat(filename); statErr == nil {
+				held = time.Since(fi.ModTime()) < lockGracePeriod
+			}
+		}
+		if held {
+			return nil, false, fmt.Errorf("%s: %w", filename, ErrLocked)
+		}
+		// Stale lock file, or removed in the meantime; try again.
+		if err == nil {
+			stale = true
+			os.Remove(filename)
+		}
+	}
+}
+
+// Unlock removes the lock file.
+func (l *Lock) Unlock() error {
+	return os.Remove(l.filename)
+}
+
+// processAlive checks whether a process with the given pid exists. If that
+// can't be determined, the process is assumed to be alive.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !(errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH))
+}
+
+// LinkTree recreates the directory tree src in dest, with hard links to the
+// files in src. Files that can't be linked are copied.
+func LinkTree(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if os.Link(path, target) == nil {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return WriteFile(target, b, info.Mode().Perm())
+	})
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package fileutil
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplaceDirAndRecover(t *testing.T) {
+	root, err := ioutil.TempDir("", "fileutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dest := filepath.Join(root, "note")
+	for _, content := range []string{"v1", "v2"} {
+		tmp, err := TempDir(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(filepath.Join(tmp, "note.html"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ReplaceDir(tmp, dest); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dest, "note.html"))
+		if err != nil || string(b) != content {
+			t.Errorf("Expected %q, got %q (%v)", content, b, err)
+		}
+	}
+
+	// Simulate a crash after dest was moved away.
+	if err := os.Rename(dest, dest+oldSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dest+TempSuffix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Recover(root); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := ioutil.ReadDir(root)
+	if len(entries) != 1 || entries[0].Name() != "note" {
+		t.Errorf("Expected only the restored note after recovery, got %v", entries)
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	root, err := ioutil.TempDir("", "fileutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	filename := filepath.Join(root, "lock")
+
+	l, stale, err := TryLock(filename)
+	if err != nil || stale {
+		t.Fatalf("Expected fresh lock, got stale = %v, err = %v", stale, err)
+	}
+	if _, _, err := TryLock(filename); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lock file of a process that doesn't exist anymore is stale.
+	if err := ioutil.WriteFile(filename, []byte("2147483647\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, stale, err = TryLock(filename)
+	if err != nil || !stale {
+		t.Fatalf("Expected stale lock to be taken over, got stale = %v, err = %v", stale, err)
+	}
+	l.Unlock()
+
+	// An empty lock file might be about to get its pid
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := TryLock(filename); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for a new empty lock file, got %v", err)
+	}
+	// ... but not if it stays empty
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(filename, old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, stale, err = TryLock(filename)
+	if err != nil || !stale {
+		t.Fatalf("Expected empty lock to be taken over, got stale = %v, err = %v", stale, err)
+	}
+	l.Unlock()
+}
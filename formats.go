@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// noteFormat is a file format notes can be written in.
+type noteFormat struct {
+	extension string
+	convert   func(note noteWithResources, w io.Writer) error
+}
+
+var (
+	formatFlag          = flag.String("format", "html", "Comma separated list of formats to write notes in: "+strings.Join(formatNames(), ", "))
+	enexPerNotebookFlag = flag.Bool("enex_per_notebook", false, "With --format=enex, also write one .enex file per notebook when syncing")
+
+	noteFormats = map[string]noteFormat{
+		"html":     {".html", noteWithResources.convertToHtml},
+		"enex":     {".enex", noteWithResources.convertToEnex},
+		"markdown": {".md", noteWithResources.convertToMarkdown},
+		// standalone is HTML with all images embedded. It has the same
+		// extension as html, so only one of them can be used.
+		"standalone": {".html", noteWithResources.convertToStandaloneHtml},
+	}
+
+	// formats are the formats selected with --format
+	formats []string
+)
+
+func formatNames() []string {
+	res := []string{}
+	for name := range noteFormats {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func parseFormats(s string) ([]string, error) {
+	res := []string{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if _, ok := noteFormats[f]; !ok {
+			return nil, fmt.Errorf("%q is not a valid format.", f)
+		}
+		res = append(res, f)
+	}
+	if contains(res, "html") && contains(res, "standalone") {
+		return nil, errors.New("The formats html and standalone can't be used together.")
+	}
+	return res, nil
+}
+
+func hasFormat(name string) bool {
+	return contains(formats, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
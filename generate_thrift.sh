@@ -27,7 +27,7 @@ for f in UserStore.thrift NoteStore.thrift; do
     -nowarn \
     --allow-64bit-consts \
     --allow-neg-keys \
-    --gen go:package_prefix=github.com/asig/evernote-backup/,thrift_import=github.com/apache/thrift/lib/go/thrift \
+    --gen go:package_prefix=github.com/asig/duplikator/,thrift_import=github.com/apache/thrift/lib/go/thrift \
     -r \
     -I ${SRCDIR} \
     --out .  \
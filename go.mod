@@ -4,4 +4,6 @@ require (
 	github.com/apache/thrift v0.12.0
 	github.com/mrjones/oauth v0.0.0-20190623134757-126b35219450
 	golang.org/x/net v0.0.0-20190724013045-ca1201d0de80
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
 )
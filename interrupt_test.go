@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestHandleSignals(t *testing.T) {
+	defer func(ctx context.Context) { runContext = ctx }(runContext)
+	handleSignals()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skipf("Can't send SIGINT: %s", err)
+	}
+	select {
+	case <-runContext.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("SIGINT did not cancel runContext")
+	}
+}
+
+// interruptedSync returns a target whose note store has three notes.
+func interruptedSync(t *testing.T) (*backupTarget, *fakeNoteStore) {
+	destDir, err := ioutil.TempDir("", "interrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := &fakeNoteStore{
+		state: edam.SyncState{UpdateCount: 9},
+		chunk: &edam.SyncChunk{ChunkHighUSN: usn(9), UpdateCount: 9},
+		notes: make(map[edam.GUID]*edam.Note),
+	}
+	for i := int32(1); i <= 3; i++ {
+		guid, title, content, active := edam.GUID(fmt.Sprintf("note%d", i)), fmt.Sprintf("Note %d", i), enmlHeader+"<en-note>Hi</en-note>", true
+		note := &edam.Note{GUID: &guid, Title: &title, Content: &content, Active: &active, UpdateSequenceNum: usn(i * 3)}
+		ns.chunk.Notes = append(ns.chunk.Notes, note)
+		ns.notes[guid] = note
+	}
+	target := &backupTarget{ns: ns, destDir: destDir}
+	target.syncState = func(ctx context.Context) (*edam.SyncState, error) {
+		return ns.GetSyncState(ctx, "")
+	}
+	target.syncChunk = func(ctx context.Context, afterUSN int32, fullSync bool) (*edam.SyncChunk, error) {
+		return ns.GetFilteredSyncChunk(ctx, "", afterUSN, maxSyncChunkEntries, nil)
+	}
+	return target, ns
+}
+
+func TestInterruptedSyncResumes(t *testing.T) {
+	defer func(ctx context.Context) { runContext = ctx }(runContext)
+	defer func(f []string) { formats = f }(formats)
+	formats = []string{"html"}
+	target, ns := interruptedSync(t)
+	defer os.RemoveAll(target.destDir)
+
+	// The sync is interrupted while the third note is downloaded
+	ctx, cancel := context.WithCancel(context.Background())
+	runContext = ctx
+	ns.getNote = func(guid edam.GUID) error {
+		if guid == "note3" {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}
+	if err := target.sync(); err == nil {
+		t.Fatal("Expected the sync to fail")
+	}
+	repo, err := repository.Load(target.destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, guid := range []string{"note1", "note2"} {
+		if _, ok := repo.Get(guid); !ok {
+			t.Errorf("Expected %s to be saved", guid)
+		}
+	}
+	if _, ok := repo.Get("note3"); ok {
+		t.Errorf("Expected note3 not to be saved")
+	}
+	if got := repo.SyncState("").UpdateCount; got != 0 {
+		t.Errorf("Expected the sync state not to be updated, got %d", got)
+	}
+
+	// The next run only downloads the missing note
+	runContext = context.Background()
+	ns.getNote = nil
+	ns.fetched = nil
+	if err := target.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ns.fetched, []edam.GUID{"note3"}) {
+		t.Errorf("Expected only note3 to be downloaded, got %v", ns.fetched)
+	}
+	repo, err = repository.Load(target.destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.SyncState("").UpdateCount; got != 9 {
+		t.Errorf("Expected update count 9, got %d", got)
+	}
+}
+
+func TestSyncCheckpoints(t *testing.T) {
+	defer func(d time.Duration) { checkpointInterval = d }(checkpointInterval)
+	checkpointInterval = 0
+	defer func(f []string) { formats = f }(formats)
+	formats = []string{"html"}
+	target, ns := interruptedSync(t)
+	defer os.RemoveAll(target.destDir)
+
+	// When the third note is downloaded, the first one is handled for sure
+	// and must have been saved
+	ns.getNote = func(guid edam.GUID) error {
+		if guid != "note3" {
+			return nil
+		}
+		repo, err := repository.Load(target.destDir)
+		if err != nil {
+			return err
+		}
+		if e, ok := repo.Get("note1"); !ok || e.UpdateSequenceNum != 3 {
+			t.Errorf("Expected note1 with USN 3 in the checkpoint, got %+v", e)
+		}
+		return nil
+	}
+	if err := target.sync(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,364 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/repository"
+)
+
+// attachmentsDirName is the directory of an Obsidian vault that holds the
+// attachments of all notes.
+const attachmentsDirName = "_attachments"
+
+var (
+	layoutFlag = flag.String("layout", "notebooks", "How notes are laid out on disk: notebooks or obsidian")
+
+	tagRegexp = regexp.MustCompile(`[^\p{L}\p{N}_/-]+`)
+)
+
+// noteLayout decides where and how notes are written to disk.
+type noteLayout interface {
+	save(note noteWithResources) error
+	// path returns the file or directory a note is stored in. If the entry
+	// has no recorded path, it's where the note belongs according to its
+	// title and notebook.
+	path(e *repository.Entry) string
+	remove(e *repository.Entry)
+	// move moves a note that is already on disk to where the updated entry
+	// belongs. The path recorded in the updated entry is ignored.
+	move(from, to *repository.Entry) error
+	// archive moves a note out of the way into dir and returns its new
+	// location, or "" if the note wasn't on disk.
+	archive(e *repository.Entry, dir string) (string, error)
+	renameNotebook(from, to *repository.Notebook) error
+}
+
+func newNoteLayout(destDir string, repo *repository.Repo) (noteLayout, error) {
+	switch *layoutFlag {
+	case "notebooks":
+		return notebookLayout{destDir, repo}, nil
+	case "obsidian":
+		return obsidianLayout{destDir, repo}, nil
+	}
+	return nil, fmt.Errorf("%q is not a valid layout.", *layoutFlag)
+}
+
+// notebookLayout puts every note in its own directory, named after its title
+// and GUID, below <stack>/<notebook>.
+type notebookLayout struct {
+	destDir string
+	repo    *repository.Repo
+}
+
+func (l notebookLayout) noteDir(e *repository.Entry) string {
+	if e.Path != "" {
+		return filepath.Join(l.destDir, filepath.FromSlash(e.Path))
+	}
+	nb, _ := l.repo.Notebook(e.NotebookGUID)
+	return baseName(notebookDir(l.destDir, nb), e.Title, e.GUID)
+}
+
+func (l notebookLayout) path(e *repository.Entry) string {
+	return l.noteDir(e)
+}
+
+func (l notebookLayout) save(note noteWithResources) error {
+	return note.save()
+}
+
+func (l notebookLayout) remove(e *repository.Entry) {
+	dir := l.noteDir(e)
+	os.RemoveAll(dir)
+	removeEmptyDirs(filepath.Dir(dir), l.destDir)
+}
+
+func (l notebookLayout) move(from, to *repository.Entry) error {
+	return moveFile(l.noteDir(from), l.noteDir(withoutPath(to)), l.destDir)
+}
+
+func (l notebookLayout) archive(e *repository.Entry, dir string) (string, error) {
+	src := l.noteDir(e)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return "", nil
+	}
+	dest := filepath.Join(dir, filepath.Base(src))
+	return dest, moveFile(src, dest, l.destDir)
+}
+
+func (l notebookLayout) renameNotebook(from, to *repository.Notebook) error {
+	return moveFile(notebookDir(l.destDir, from), notebookDir(l.destDir, to), l.destDir)
+}
+
+// obsidianLayout writes a Markdown vault that Obsidian and Logseq can open
+// directly: notes are stored as <stack>/<notebook>/<title>.md, attachments of
+// all notes share one folder.
+type obsidianLayout struct {
+	destDir string
+	repo    *repository.Repo
+}
+
+func (l obsidianLayout) notebookDir(notebookGUID string) string {
+	nb, _ := l.repo.Notebook(notebookGUID)
+	return notebookDir(l.destDir, nb)
+}
+
+// noteFile returns the file a note is written to. Notes are named after their
+// title; if another note with the same title already exists, the GUID is
+// added to the name.
+func (l obsidianLayout) noteFile(title, guid, notebookGUID string) string {
+	dir := l.notebookDir(notebookGUID)
+	filename := filepath.Join(dir, makeFilename(title)+".md")
+	if _, err := os.Stat(filename); err == nil && !ownsMarkdownFile(filename, guid) {
+		filename = filepath.Join(dir, makeFilename(title)+" "+guid+".md")
+	}
+	return filename
+}
+
+func (l obsidianLayout) path(e *repository.Entry) string {
+	if e.Path != "" {
+		return filepath.Join(l.destDir, filepath.FromSlash(e.Path))
+	}
+	return l.noteFile(e.Title, e.GUID, e.NotebookGUID)
+}
+
+// linkTarget returns the name of the file the note with the given GUID and
+// title is stored in, which is what Obsidian resolves wikilinks against.
+func (l obsidianLayout) linkTarget(guid, title string) string {
+	filename := makeFilename(title) + ".md"
+	if e, ok := l.repo.Get(guid); ok {
+		if e.Title == title {
+			filename = l.path(e)
+		} else {
+			filename = l.noteFile(title, guid, e.NotebookGUID)
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(filename), ".md")
+}
+
+func (l obsidianLayout) attachmentFile(note noteWithResources, hash string) string {
+	return filepath.Join(l.destDir, attachmentsDirName, hash[:8]+"-"+note.attachmentName(hash))
+}
+
+func (l obsidianLayout) save(note noteWithResources) error {
+	filename := l.noteFile(note.note.GetTitle(), string(note.note.GetGUID()), note.note.GetNotebookGuid())
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for hash, res := range note.resources {
+		attachment := l.attachmentFile(note, hash)
+		if err := os.MkdirAll(filepath.Dir(attachment), 0755); err != nil {
+			return err
+		}
+		if err := writeAttachment(l.destDir, attachment, hash, res.Data.Body); err != nil {
+			return err
+		}
+		if err := writeRecognition(res, attachment); err != nil {
+			return err
+		}
+	}
+
+	m := markdownConverter{
+		note: note,
+		attachmentLink: func(hash string) string {
+			rel, _ := filepath.Rel(dir, l.attachmentFile(note, hash))
+			return filepath.ToSlash(rel)
+		},
+		wikiLinks:      true,
+		wikiLinkTarget: l.linkTarget,
+	}
+	body, err := m.convert()
+	if err != nil {
+		return err
+	}
+	tags := []string{}
+	for _, t := range note.note.TagNames {
+		tags = append(tags, "#"+tagRegexp.ReplaceAllString(t, "_"))
+	}
+	if len(tags) > 0 {
+		body = strings.Join(tags, " ") + "\n\n" + body
+	}
+	return fileutil.WriteFile(filename, []byte(note.markdownFrontMatter()+body), 0644)
+}
+
+func (l obsidianLayout) remove(e *repository.Entry) {
+	// Attachments are shared and kept.
+	filename := l.path(e)
+	if ownsMarkdownFile(filename, e.GUID) {
+		os.Remove(filename)
+		removeEmptyDirs(filepath.Dir(filename), l.destDir)
+	}
+}
+
+func (l obsidianLayout) move(from, to *repository.Entry) error {
+	src := l.path(from)
+	dest := l.path(withoutPath(to))
+	if err := moveFile(src, dest, l.destDir); err != nil {
+		return err
+	}
+	return l.rewriteAttachmentLinks(dest, filepath.Dir(src))
+}
+
+func (l obsidianLayout) archive(e *repository.Entry, dir string) (string, error) {
+	src := l.path(e)
+	if !ownsMarkdownFile(src, e.GUID) {
+		return "", nil
+	}
+	dest := filepath.Join(dir, filepath.Base(src))
+	if err := moveFile(src, dest, l.destDir); err != nil {
+		return "", err
+	}
+	return dest, l.rewriteAttachmentLinks(dest, filepath.Dir(src))
+}
+
+func (l obsidianLayout) renameNotebook(from, to *repository.Notebook) error {
+	src := notebookDir(l.destDir, from)
+	dest := notebookDir(l.destDir, to)
+	if err := moveFile(src, dest, l.destDir); err != nil {
+		return err
+	}
+	files, _ := filepath.Glob(filepath.Join(dest, "*.md"))
+	for _, f := range files {
+		if err := l.rewriteAttachmentLinks(f, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteAttachmentLinks fixes the relative attachment links of a note that
+// was moved from oldDir.
+func (l obsidianLayout) rewriteAttachmentLinks(filename, oldDir string) error {
+	attachments := filepath.Join(l.destDir, attachmentsDirName)
+	oldPrefix, _ := filepath.Rel(oldDir, attachments)
+	newPrefix, _ := filepath.Rel(filepath.Dir(filename), attachments)
+	if oldPrefix == newPrefix {
+		return nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	s := string(b)
+	for _, start := range []string{"](", "](<"} {
+		s = strings.Replace(s, start+filepath.ToSlash(oldPrefix)+"/", start+filepath.ToSlash(newPrefix)+"/", -1)
+	}
+	return fileutil.WriteFile(filename, []byte(s), 0644)
+}
+
+// withoutPath returns a copy of the entry without its recorded path, i.e. one
+// that the layouts place according to its title and notebook.
+func withoutPath(e *repository.Entry) *repository.Entry {
+	c := *e
+	c.Path = ""
+	return &c
+}
+
+// recordPath stores where a note that was just written or moved is located.
+func recordPath(destDir string, layout noteLayout, e *repository.Entry) {
+	rel, err := filepath.Rel(destDir, layout.path(withoutPath(e)))
+	if err != nil {
+		return
+	}
+	e.Path = filepath.ToSlash(rel)
+}
+
+// ownsMarkdownFile checks whether the front matter of a Markdown file names
+// the given GUID.
+func ownsMarkdownFile(filename, guid string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b, _ := json.Marshal(guid)
+	expected := "guid: " + string(b)
+	scanner := bufio.NewScanner(f)
+	for i := 0; i < 3 && scanner.Scan(); i++ {
+		if scanner.Text() == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// moveFile renames a file or directory, creating missing parent directories
+// of dest and removing parent directories of src that became empty. A
+// directory moved onto an existing directory is merged into it.
+func moveFile(src, dest, root string) error {
+	if src == dest {
+		return nil
+	}
+	fi, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if fi != nil && fi.IsDir() {
+		if di, err := os.Stat(dest); err == nil && di.IsDir() {
+			return mergeDir(src, dest, root)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return err
+	}
+	removeEmptyDirs(filepath.Dir(src), root)
+	return nil
+}
+
+// mergeDir moves the contents of src into the existing directory dest.
+func mergeDir(src, dest, root string) error {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if err := moveFile(filepath.Join(src, fi.Name()), filepath.Join(dest, fi.Name()), root); err != nil {
+			return err
+		}
+	}
+	removeEmptyDirs(src, root)
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but excluding, root as
+// long as they are empty.
+func removeEmptyDirs(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
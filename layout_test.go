@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestObsidianWikiLinksToRenamedFiles(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "obsidian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	l := obsidianLayout{destDir, repo}
+	save := func(guid, title, content string, linked map[string]string) {
+		content = enmlHeader + "<en-note>" + content + "</en-note>"
+		g := edam.GUID(guid)
+		note := noteWithResources{note: &edam.Note{GUID: &g, Title: &title, Content: &content}, linked: linked}
+		if err := l.save(note); err != nil {
+			t.Fatal(err)
+		}
+		repo.GetOrAdd(guid).Title = title
+	}
+	first := "11111111-1111-2222-3333-444455556666"
+	second := "22222222-1111-2222-3333-444455556666"
+	save(first, "Note", "<div>first</div>", nil)
+	save(second, "Note", "<div>second</div>", nil)
+
+	href := "evernote:///view/123/s1/" + second + "/" + second + "/"
+	save("33333333-1111-2222-3333-444455556666", "Index", `<div><a href="`+href+`">Note</a></div>`, map[string]string{second: "Note"})
+
+	b, err := ioutil.ReadFile(filepath.Join(destDir, "Index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "Note " + second
+	if _, err := os.Stat(filepath.Join(destDir, target+".md")); err != nil {
+		t.Fatal(err)
+	}
+	if link := "[[" + target + "|Note]]"; !strings.Contains(string(b), link) {
+		t.Errorf("Expected %q in %q", link, b)
+	}
+}
+
+func TestRenameNotebooksSwappingNames(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "obsidian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	l := obsidianLayout{destDir, repo}
+	repo.PutNotebook(&repository.Notebook{GUID: "nb-a", Name: "A"})
+	repo.PutNotebook(&repository.Notebook{GUID: "nb-b", Name: "B"})
+	for _, nb := range []string{"A", "B"} {
+		e := repo.GetOrAdd("note-" + nb)
+		e.NotebookGUID = "nb-" + strings.ToLower(nb)
+		e.Path = nb + "/" + nb + ".md"
+		if err := os.MkdirAll(filepath.Join(destDir, nb), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(destDir, nb, nb+".md"), []byte(nb), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chunks := newSyncChunks()
+	a, b := "A", "B"
+	chunks.notebooks["nb-a"] = &edam.Notebook{Name: &b}
+	chunks.notebooks["nb-b"] = &edam.Notebook{Name: &a}
+	target := &backupTarget{destDir: destDir}
+	if err := target.applyNotebooksAndTags(repo, l, chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ guid, path string }{{"note-A", "B/A.md"}, {"note-B", "A/B.md"}} {
+		e, _ := repo.Get(c.guid)
+		if e.Path != c.path {
+			t.Errorf("Expected %s at %s, got %s", c.guid, c.path, e.Path)
+		}
+		if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(c.path))); err != nil {
+			t.Error(err)
+		}
+	}
+	if infos, _ := ioutil.ReadDir(destDir); len(infos) != 2 {
+		t.Errorf("Expected only A and B in %s, got %d entries", destDir, len(infos))
+	}
+}
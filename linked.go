@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asig/duplikator/edam"
+)
+
+// linkedDirName is the directory below --dest_dir that holds the backups of
+// linked notebooks. Every linked notebook gets its own subdirectory with its
+// own repository, as its USNs are unrelated to the ones of the account.
+const linkedDirName = "_linked"
+
+func syncLinkedNotebooks(destDir string) error {
+	ctx := runContext
+	linkedNotebooks, err := ns.ListLinkedNotebooks(ctx, client.authToken)
+	if err != nil {
+		return err
+	}
+
+	linkedDir := filepath.Join(destDir, linkedDirName)
+	existing := existingLinkedDirs(linkedDir)
+	for _, ln := range linkedNotebooks {
+		if *businessFlag && ln.BusinessId != nil {
+			// Already backed up together with the business notes
+			continue
+		}
+		guid := string(ln.GetGUID())
+		destDir := filepath.Join(linkedDir, makeFilename(ln.GetShareName())+"-"+guid)
+		if old, ok := findLinkedDir(existing, guid); ok {
+			if old != destDir {
+				log.Printf("Linked notebook %q was renamed, moving %s to %s", ln.GetShareName(), old, destDir)
+				if err := os.Rename(old, destDir); err != nil {
+					return err
+				}
+			}
+			delete(existing, old)
+		}
+
+		t, err := linkedNotebookTarget(ctx, ln, destDir)
+		if err != nil {
+			log.Printf("Can't access linked notebook %q: %s", ln.GetShareName(), err)
+			continue
+		}
+		log.Printf("Syncing linked notebook %q", ln.GetShareName())
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return err
+		}
+		if err := t.sync(); err != nil {
+			return err
+		}
+	}
+
+	// Whatever is left over is no longer linked to the account
+	for dir := range existing {
+		if err := removeLinkedDir(destDir, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLinkedDir handles the backup of a notebook that is no longer linked
+// to the account. As that might only be temporary, e.g. if it was unshared
+// by mistake, the backup is never deleted: with --vanished=keep it stays
+// where it is, otherwise it is moved to _expunged.
+func removeLinkedDir(destDir, dir string) error {
+	if *vanishedFlag == "keep" {
+		log.Printf("Keeping linked notebook %s, it is no longer linked", dir)
+		return nil
+	}
+	rel, err := filepath.Rel(destDir, dir)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(destDir, expungedDirName, time.Now().UTC().Format(expungedTimeFormat), rel)
+	log.Printf("Moving linked notebook %s to %s, it is no longer linked", dir, dest)
+	return moveFile(dir, dest, destDir)
+}
+
+// linkedNotebookTarget authenticates to the shard a linked notebook lives on.
+func linkedNotebookTarget(ctx context.Context, ln *edam.LinkedNotebook, destDir string) (*backupTarget, error) {
+	linkedNs, err := client.getNoteStoreForURL(ln.GetNoteStoreUrl())
+	if err != nil {
+		return nil, err
+	}
+	authToken := client.authToken
+	if ln.SharedNotebookGlobalId != nil {
+		authResult, err := linkedNs.AuthenticateToSharedNotebook(ctx, ln.GetSharedNotebookGlobalId(), client.authToken)
+		if err != nil {
+			return nil, err
+		}
+		authToken = authResult.AuthenticationToken
+	}
+
+	t := &backupTarget{ns: linkedNs, authToken: authToken, destDir: destDir, noteStoreURL: ln.GetNoteStoreUrl()}
+	t.syncState = func(ctx context.Context) (*edam.SyncState, error) {
+		return t.ns.GetLinkedNotebookSyncState(ctx, t.authToken, ln)
+	}
+	t.syncChunk = func(ctx context.Context, afterUSN int32, fullSync bool) (*edam.SyncChunk, error) {
+		return t.ns.GetLinkedNotebookSyncChunk(ctx, t.authToken, ln, afterUSN, maxSyncChunkEntries, fullSync)
+	}
+	return t, nil
+}
+
+// existingLinkedDirs returns the set of linked notebook backup directories.
+func existingLinkedDirs(linkedDir string) map[string]bool {
+	res := make(map[string]bool)
+	files, err := ioutil.ReadDir(linkedDir)
+	if err != nil {
+		return res
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		res[filepath.Join(linkedDir, f.Name())] = true
+	}
+	return res
+}
+
+func findLinkedDir(dirs map[string]bool, guid string) (string, bool) {
+	for dir := range dirs {
+		if strings.HasSuffix(dir, "-"+guid) {
+			return dir, true
+		}
+	}
+	return "", false
+}
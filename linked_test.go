@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveLinkedDir(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "linked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	defer func(p string) { *vanishedFlag = p }(*vanishedFlag)
+
+	dir := filepath.Join(destDir, linkedDirName, "Shared-guid")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	*vanishedFlag = "keep"
+	if err := removeLinkedDir(destDir, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Linked notebook was not kept: %s", err)
+	}
+
+	*vanishedFlag = "delete"
+	if err := removeLinkedDir(destDir, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Linked notebook was not moved away")
+	}
+	moved, _ := filepath.Glob(filepath.Join(destDir, expungedDirName, "*", linkedDirName, "Shared-guid"))
+	if len(moved) != 1 {
+		t.Errorf("Linked notebook was not moved to %s", expungedDirName)
+	}
+}
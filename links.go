@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	gosync "sync"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/repository"
+)
+
+// unresolvedLinksFileName is the report of all internal links in the backup
+// that point to notes that are not in the backup. It is written after every
+// sync.
+const unresolvedLinksFileName = "unresolved_links.json"
+
+var hrefRegexp = regexp.MustCompile(`href="([^"]*)"`)
+
+type unresolvedLink struct {
+	GUID  string `json:"guid"`
+	Title string `json:"title"`
+	// Target is the GUID of the note the link points to.
+	Target string `json:"target,omitempty"`
+	// Unknown is set for notes whose links were never recorded, e.g.
+	// because they were backed up by an older version. Target is empty.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// linkReport collects the unresolved links of a sync.
+type linkReport struct {
+	mu    gosync.Mutex
+	links []unresolvedLink
+}
+
+var unresolvedLinks = &linkReport{}
+
+// addRepo adds the links of the notes in the repository that point to notes
+// that are not in it, and the notes whose links are not known.
+func (r *linkReport) addRepo(repo *repository.Repo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	guids := repo.GUIDs()
+	sort.Strings(guids)
+	for _, guid := range guids {
+		e, _ := repo.Get(guid)
+		if e.Links == nil {
+			r.links = append(r.links, unresolvedLink{GUID: e.GUID, Title: e.Title, Unknown: true})
+			continue
+		}
+		for _, target := range e.Links {
+			if _, ok := repo.Get(target); !ok {
+				r.links = append(r.links, unresolvedLink{GUID: e.GUID, Title: e.Title, Target: target})
+			}
+		}
+	}
+}
+
+// write writes the report to destDir and starts a new one.
+func (r *linkReport) write(destDir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	links := r.links
+	if links == nil {
+		links = []unresolvedLink{}
+	}
+	r.links = nil
+	unknown := 0
+	for _, l := range links {
+		if l.Unknown {
+			unknown++
+		}
+	}
+	if len(links) > unknown {
+		log.Printf("%d internal links could not be resolved, see %s", len(links)-unknown, unresolvedLinksFileName)
+	}
+	if unknown > 0 {
+		log.Printf("The links of %d notes are not known, see %s", unknown, unresolvedLinksFileName)
+	}
+	b, err := json.MarshalIndent(links, "", " ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(filepath.Join(destDir, unresolvedLinksFileName), b, 0644)
+}
+
+// linkedNoteGUIDs returns the GUIDs of the notes that ENML content links
+// to.
+func linkedNoteGUIDs(content string) []string {
+	res := []string{}
+	seen := make(map[string]bool)
+	for _, m := range hrefRegexp.FindAllStringSubmatch(content, -1) {
+		if guid, ok := noteLinkGUID(html.UnescapeString(m[1])); ok && !seen[guid] {
+			seen[guid] = true
+			res = append(res, guid)
+		}
+	}
+	return res
+}
+
+// htmlPaths returns the HTML file of every note that is in the repository
+// after the sync, for rewriting links between notes. Notes that are
+// downloaded end up where their title and notebook say.
+func (t *backupTarget) htmlPaths(repo *repository.Repo, layout notebookLayout, chunks *syncChunks, download []string) map[string]string {
+	res := make(map[string]string)
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		res[guid] = noteFileName(layout.noteDir(e), e.Title, noteFormats["html"].extension)
+	}
+	for _, guid := range download {
+		md, ok := chunks.notes[guid]
+		if !ok {
+			continue
+		}
+		nb, _ := repo.Notebook(md.GetNotebookGuid())
+		dir := baseName(notebookDir(t.destDir, nb), md.GetTitle(), guid)
+		res[guid] = noteFileName(dir, md.GetTitle(), noteFormats["html"].extension)
+	}
+	return res
+}
+
+// notesWithStaleLinks returns the notes whose HTML file links to notes whose
+// HTML file appeared, moved or is gone since it was written. Notes that were
+// downloaded in this sync were written with the HTML files in rendered, all
+// others with the ones in before; after are the HTML files after the sync.
+func notesWithStaleLinks(repo *repository.Repo, before, rendered, after map[string]string, download []string) []string {
+	downloaded := make(map[string]bool)
+	for _, guid := range download {
+		downloaded[guid] = true
+	}
+	var res []string
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		if e.Departed != nil {
+			continue
+		}
+		paths := before
+		if downloaded[guid] {
+			paths = rendered
+		}
+		for _, target := range e.Links {
+			if paths[target] != after[target] {
+				res = append(res, guid)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// rewriteNoteLinks writes the HTML file of a note that is in the backup again,
+// with links to the HTML files in htmlPaths. The note is read from its
+// note.json and its attachments, nothing is downloaded.
+func rewriteNoteLinks(l notebookLayout, e *repository.Entry, htmlPaths map[string]string) error {
+	dir := l.noteDir(e)
+	m, err := readNoteMetadata(filepath.Join(dir, noteMetadataFileName))
+	if err != nil {
+		return err
+	}
+	note := noteWithResources{
+		note:      m.Note,
+		resources: make(map[string]*edam.Resource),
+		destDir:   l.destDir,
+		htmlPaths: htmlPaths,
+	}
+	note.notebook, _ = l.repo.Notebook(e.NotebookGUID)
+	paths := make(map[string]string)
+	for _, r := range m.Resources {
+		paths[r.MD5] = r.Path
+	}
+	for _, r := range note.note.Resources {
+		hash := hex.EncodeToString(r.GetData().GetBodyHash())
+		if hasFormat("standalone") {
+			// Attachments are embedded
+			body, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(paths[hash])))
+			if err != nil {
+				return err
+			}
+			r.Data = resourceData(body)
+		}
+		note.resources[hash] = r
+	}
+
+	for _, format := range []string{"html", "standalone"} {
+		if !hasFormat(format) {
+			continue
+		}
+		var b bytes.Buffer
+		if err := noteFormats[format].convert(note, &b); err != nil {
+			return err
+		}
+		filename := noteFileName(dir, note.note.GetTitle(), noteFormats[format].extension)
+		if err := fileutil.WriteFile(filename, b.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillLinks records the links of notes that were backed up before links
+// were recorded, as far as their note.json is there.
+func backfillLinks(repo *repository.Repo, l notebookLayout) {
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		if e.Links != nil {
+			continue
+		}
+		if m, err := readNoteMetadata(filepath.Join(l.noteDir(e), noteMetadataFileName)); err == nil && m.Note != nil {
+			e.Links = linkedNoteGUIDs(m.Note.GetContent())
+		}
+	}
+}
+
+// localLink returns the relative link to the local copy of the note an
+// internal Evernote link points to.
+func (note noteWithResources) localLink(href string) (string, bool) {
+	guid, ok := noteLinkGUID(href)
+	if !ok || note.htmlPaths == nil {
+		return "", false
+	}
+	target, ok := note.htmlPaths[guid]
+	if !ok {
+		return "", false
+	}
+	rel, err := filepath.Rel(note.baseName(), target)
+	if err != nil {
+		return "", false
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).String(), true
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestLocalLinks(t *testing.T) {
+	known := "0c9d9a59-ff1c-4c32-b3a8-11b3f9ed9f3a"
+	unknown := "5e0d0b4c-3f9e-4b7e-9a3d-2c1b0a9f8e7d"
+	content := enmlHeader + `<en-note>` +
+		`<a href="evernote:///view/123/s1/` + known + `/` + known + `/">known</a>` +
+		`<a href="https://www.evernote.com/shard/s1/nl/123/` + unknown + `">unknown</a>` +
+		`<a href="https://example.com/">external</a>` +
+		`</en-note>`
+	title := "Source"
+	guid := edam.GUID("source-guid")
+	note := noteWithResources{
+		note:     &edam.Note{GUID: &guid, Title: &title, Content: &content},
+		destDir:  "/backup",
+		notebook: &repository.Notebook{Name: "Work"},
+		htmlPaths: map[string]string{
+			known: "/backup/Home/My target-" + known + "/My target.html",
+		},
+	}
+
+	var b bytes.Buffer
+	if err := note.convertToHtml(&b); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	expected := `href="../../Home/My%20target-` + known + `/My%20target.html"`
+	if !strings.Contains(html, expected) {
+		t.Errorf("Expected %s in %s", expected, html)
+	}
+	if !strings.Contains(html, `href="https://example.com/"`) {
+		t.Errorf("External link was changed: %s", html)
+	}
+	if got := linkedNoteGUIDs(content); len(got) != 2 || got[0] != known || got[1] != unknown {
+		t.Errorf("Expected links to %s and %s, got %v", known, unknown, got)
+	}
+}
+
+func TestLinksAcrossSyncs(t *testing.T) {
+	repo := repository.New("/backup")
+	source := repo.GetOrAdd("source")
+	source.Links = []string{"target", "missing"}
+	repo.GetOrAdd("target").Links = []string{}
+	repo.GetOrAdd("unrelated").Links = []string{"source"}
+
+	report := &linkReport{}
+	report.addRepo(repo)
+	if len(report.links) != 1 || report.links[0].GUID != "source" || report.links[0].Target != "missing" {
+		t.Errorf("Unexpected unresolved links %+v", report.links)
+	}
+
+	// The target was renamed in a later sync, so the source needs new links
+	before := map[string]string{"source": "/backup/a.html", "target": "/backup/old.html"}
+	after := map[string]string{"source": "/backup/a.html", "target": "/backup/new.html"}
+	if got := notesWithStaleLinks(repo, before, after, after, []string{"target"}); len(got) != 1 || got[0] != "source" {
+		t.Errorf("Expected [source], got %v", got)
+	}
+	if got := notesWithStaleLinks(repo, after, after, after, nil); len(got) != 0 {
+		t.Errorf("Expected no notes with stale links, got %v", got)
+	}
+
+	// The target is gone after the sync
+	gone := map[string]string{"source": "/backup/a.html"}
+	if got := notesWithStaleLinks(repo, after, after, gone, nil); len(got) != 1 || got[0] != "source" {
+		t.Errorf("Expected [source], got %v", got)
+	}
+	// The source was downloaded in the sync, but before the target was gone
+	if got := notesWithStaleLinks(repo, gone, after, gone, []string{"source"}); len(got) != 1 || got[0] != "source" {
+		t.Errorf("Expected [source], got %v", got)
+	}
+	if got := notesWithStaleLinks(repo, after, gone, gone, []string{"source"}); len(got) != 0 {
+		t.Errorf("Expected no notes with stale links, got %v", got)
+	}
+}
+
+func TestUnknownLinks(t *testing.T) {
+	repo := repository.New("/backup")
+	repo.GetOrAdd("old").Title = "Old"
+	repo.GetOrAdd("new").Links = []string{}
+
+	report := &linkReport{}
+	report.addRepo(repo)
+	if len(report.links) != 1 || report.links[0].GUID != "old" || !report.links[0].Unknown {
+		t.Errorf("Unexpected unresolved links %+v", report.links)
+	}
+}
+
+func TestRewriteLinksFromBackup(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	defer func(f []string) { formats = f }(formats)
+	formats = []string{"html"}
+
+	target := "0c9d9a59-ff1c-4c32-b3a8-11b3f9ed9f3a"
+	content := enmlHeader + `<en-note><a href="evernote:///view/123/s1/` + target + `/` + target + `/">target</a></en-note>`
+	title := "Source"
+	guid := edam.GUID("source-guid")
+	note := noteWithResources{
+		note:      &edam.Note{GUID: &guid, Title: &title, Content: &content},
+		destDir:   destDir,
+		htmlPaths: map[string]string{target: filepath.Join(destDir, "Old-"+target, "Old.html")},
+	}
+	if err := note.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := repository.New(destDir)
+	e := repo.Add(&repository.Entry{GUID: string(guid), Title: title, Links: []string{target}})
+	htmlPaths := map[string]string{target: filepath.Join(destDir, "New-"+target, "New.html")}
+	if err := rewriteNoteLinks(notebookLayout{destDir, repo}, e, htmlPaths); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(note.noteFileName(".html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `href="../New-`+target+`/New.html"`) {
+		t.Errorf("Expected link to the new HTML file, got %s", b)
+	}
+}
@@ -108,7 +108,7 @@ func login(oauthClient *oauth.Consumer) (*tokenstore.Token, error) {
 		return nil, err
 	}
 
-	t := &tokenstore.Token{*token}
+	t := &tokenstore.Token{AccessToken: *token}
 	return t, nil
 }
 
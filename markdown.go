@@ -0,0 +1,401 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/asig/duplikator/edam"
+)
+
+var (
+	whitespaceRegexp     = regexp.MustCompile(`\s+`)
+	markdownEscapeRegexp = regexp.MustCompile("([\\\\`*_\\[\\]<])")
+
+	evernoteLinkRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`^evernote:///view/\d+/[^/]+/([0-9a-f-]+)/`),
+		regexp.MustCompile(`^https?://[^/]+/shard/[^/]+/nl/\d+/([0-9a-f-]+)`),
+	}
+)
+
+func (note noteWithResources) convertToMarkdown(w io.Writer) error {
+	m := markdownConverter{
+		note: note,
+		attachmentLink: func(hash string) string {
+			return filepath.ToSlash(note.attachmentFileName(hash, true))
+		},
+	}
+	body, err := m.convert()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, note.markdownFrontMatter()+body)
+	return err
+}
+
+// noteLinkGUID returns the GUID of the note an internal Evernote link
+// points to.
+func noteLinkGUID(href string) (string, bool) {
+	for _, re := range evernoteLinkRegexps {
+		if m := re.FindStringSubmatch(href); m != nil {
+			return m[1], true
+		}
+	}
+	return "", false
+}
+
+func (note noteWithResources) markdownFrontMatter() string {
+	n := note.note
+	lines := []string{"---"}
+	add := func(key string, val interface{}) {
+		b, _ := json.Marshal(val)
+		lines = append(lines, key+": "+string(b))
+	}
+	add("guid", string(n.GetGUID()))
+	add("title", n.GetTitle())
+	if note.notebook != nil {
+		add("notebook", note.notebook.Name)
+	}
+	tags := n.TagNames
+	if tags == nil {
+		tags = []string{}
+	}
+	add("tags", tags)
+	if n.Created != nil {
+		add("created", yamlTime(*n.Created))
+	}
+	if n.Updated != nil {
+		add("updated", yamlTime(*n.Updated))
+	}
+	if n.Attributes != nil && n.Attributes.SourceURL != nil {
+		add("source_url", n.Attributes.GetSourceURL())
+	}
+	lines = append(lines, "---", "", "")
+	return strings.Join(lines, "\n")
+}
+
+func yamlTime(ts edam.Timestamp) string {
+	return timestampToTime(ts).UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// markdownConverter turns an ENML tree into GitHub flavored Markdown.
+type markdownConverter struct {
+	note noteWithResources
+	// attachmentLink returns the link target of the resource with the given hash.
+	attachmentLink func(hash string) string
+	// wikiLinks turns links to other notes into [[wikilinks]].
+	wikiLinks bool
+	// wikiLinkTarget returns the file name, without extension, of the linked
+	// note with the given GUID and title. If nil, the note's title is used.
+	wikiLinkTarget func(guid, title string) string
+}
+
+func (m markdownConverter) convert() (string, error) {
+	root, err := parseEnml(m.note.note.GetContent())
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(m.blocks(root.children), "\n\n") + "\n", nil
+}
+
+func isBlockElement(name string) bool {
+	switch name {
+	case "div", "p", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "table", "pre", "blockquote", "hr", "center":
+		return true
+	}
+	return false
+}
+
+// blocks converts nodes to Markdown blocks. Consecutive inline nodes are
+// collected into one paragraph.
+func (m markdownConverter) blocks(nodes []*enmlNode) []string {
+	res := []string{}
+	var inline []*enmlNode
+	flush := func() {
+		if s := m.paragraph(m.inline(inline)); s != "" {
+			res = append(res, s)
+		}
+		inline = nil
+	}
+	for _, n := range nodes {
+		if !isBlockElement(n.name) {
+			inline = append(inline, n)
+			continue
+		}
+		flush()
+		res = append(res, m.block(n)...)
+	}
+	flush()
+	return res
+}
+
+func (m markdownConverter) block(n *enmlNode) []string {
+	switch n.name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.TrimSpace(strings.Replace(m.inline(n.children), "\\\n", " ", -1))
+		if text == "" {
+			return nil
+		}
+		return []string{strings.Repeat("#", int(n.name[1]-'0')) + " " + text}
+	case "ul", "ol":
+		return []string{m.list(n, "")}
+	case "table":
+		return []string{m.table(n)}
+	case "pre":
+		return []string{"```\n" + strings.TrimRight(n.textContent(), "\n") + "\n```"}
+	case "blockquote":
+		lines := strings.Split(strings.Join(m.blocks(n.children), "\n\n"), "\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimRight("> "+l, " ")
+		}
+		return []string{strings.Join(lines, "\n")}
+	case "hr":
+		return []string{"---"}
+	}
+	return m.blocks(n.children)
+}
+
+// paragraph cleans up a paragraph of inline Markdown. Paragraphs starting
+// with a to-do checkbox become task list items.
+func (m markdownConverter) paragraph(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, l := range lines {
+		if !strings.HasSuffix(l, "\\") {
+			lines[i] = strings.TrimSpace(l)
+		} else {
+			lines[i] = strings.TrimLeft(l, " ")
+		}
+	}
+	s = strings.TrimSuffix(strings.Join(lines, "\n"), "\\")
+	if strings.HasPrefix(s, "[ ] ") || strings.HasPrefix(s, "[x] ") {
+		s = "- " + s
+	}
+	return strings.TrimSpace(s)
+}
+
+func (m markdownConverter) list(n *enmlNode, indent string) string {
+	lines := []string{}
+	i := 1
+	for _, li := range n.children {
+		if li.name != "li" {
+			continue
+		}
+		marker := "- "
+		if n.name == "ol" {
+			marker = fmt.Sprintf("%d. ", i)
+		}
+		i++
+		var inline []*enmlNode
+		var nested []string
+		m.collectListItem(li, indent+strings.Repeat(" ", len(marker)), &inline, &nested)
+		text := strings.Replace(m.paragraph(m.inline(inline)), "\n", "\n"+indent+strings.Repeat(" ", len(marker)), -1)
+		text = strings.TrimPrefix(text, "- ")
+		lines = append(lines, indent+marker+text)
+		lines = append(lines, nested...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// collectListItem splits the content of a list item into its inline content
+// and nested lists.
+func (m markdownConverter) collectListItem(n *enmlNode, indent string, inline *[]*enmlNode, nested *[]string) {
+	for _, c := range n.children {
+		switch {
+		case c.name == "ul" || c.name == "ol":
+			*nested = append(*nested, m.list(c, indent))
+		case isBlockElement(c.name):
+			if len(*inline) > 0 {
+				*inline = append(*inline, &enmlNode{name: "br"})
+			}
+			m.collectListItem(c, indent, inline, nested)
+		default:
+			*inline = append(*inline, c)
+		}
+	}
+}
+
+func (m markdownConverter) table(n *enmlNode) string {
+	var rows [][]string
+	var collectRows func(n *enmlNode)
+	collectRows = func(n *enmlNode) {
+		for _, c := range n.children {
+			switch c.name {
+			case "tr":
+				row := []string{}
+				for _, cell := range c.children {
+					if cell.name == "td" || cell.name == "th" {
+						row = append(row, m.tableCell(cell))
+					}
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot":
+				collectRows(c)
+			}
+		}
+	}
+	collectRows(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	lines := []string{}
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m markdownConverter) tableCell(n *enmlNode) string {
+	var inline []*enmlNode
+	var nested []string
+	m.collectListItem(n, "", &inline, &nested)
+	s := m.paragraph(m.inline(inline))
+	s = strings.Replace(s, "|", "\\|", -1)
+	s = strings.Replace(s, "\\\n", "<br>", -1)
+	return strings.Replace(s, "\n", "<br>", -1)
+}
+
+func (m markdownConverter) inline(nodes []*enmlNode) string {
+	res := ""
+	for _, n := range nodes {
+		res += m.inlineNode(n)
+	}
+	return res
+}
+
+func (m markdownConverter) inlineNode(n *enmlNode) string {
+	switch n.name {
+	case "":
+		return markdownEscapeRegexp.ReplaceAllString(whitespaceRegexp.ReplaceAllString(n.text, " "), "\\$1")
+	case "br":
+		return "\\\n"
+	case "b", "strong":
+		return wrapInline(m.inline(n.children), "**")
+	case "i", "em":
+		return wrapInline(m.inline(n.children), "*")
+	case "s", "strike", "del":
+		return wrapInline(m.inline(n.children), "~~")
+	case "code", "tt":
+		return wrapInline(n.textContent(), "`")
+	case "a":
+		text := strings.TrimSpace(m.inline(n.children))
+		href := n.attrs["href"]
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = href
+		}
+		if guid, ok := noteLinkGUID(href); ok && m.wikiLinks {
+			if title, ok := m.note.linked[guid]; ok {
+				target := makeFilename(title)
+				if m.wikiLinkTarget != nil {
+					target = m.wikiLinkTarget(guid, title)
+				}
+				if text == target {
+					return "[[" + target + "]]"
+				}
+				return "[[" + target + "|" + text + "]]"
+			}
+		}
+		return "[" + text + "](" + markdownLinkTarget(href) + ")"
+	case "img":
+		return "![" + n.attrs["alt"] + "](" + markdownLinkTarget(n.attrs["src"]) + ")"
+	case "en-todo":
+		if n.attrs["checked"] == "true" {
+			return "[x] "
+		}
+		return "[ ] "
+	case "en-media":
+		return m.media(n)
+	case "en-crypt":
+		if plain, ok := decryptSection(n.attrs, n.textContent()); ok {
+			if root, err := parseEnml("<en-note>" + plain + "</en-note>"); err == nil {
+				return "`[decrypted]` " + strings.Join(m.blocks(root.children), "\\\n") + " `[/decrypted]`"
+			}
+		}
+		// Keep the section as inline HTML, so that it can still be
+		// decrypted
+		s := "<en-crypt"
+		for _, a := range []string{"cipher", "length", "hint"} {
+			if v, ok := n.attrs[a]; ok {
+				s += fmt.Sprintf(` %s="%s"`, a, html.EscapeString(v))
+			}
+		}
+		return s + ">" + whitespaceRegexp.ReplaceAllString(n.textContent(), "") + "</en-crypt>"
+	}
+	return m.inline(n.children)
+}
+
+func (m markdownConverter) media(n *enmlNode) string {
+	hash := n.attrs["hash"]
+	r, ok := m.note.resources[hash]
+	if !ok {
+		return "`[missing attachment]`"
+	}
+	filename := m.attachmentLink(hash)
+	displayName := path.Base(filename)
+	if r.Attributes != nil && r.Attributes.FileName != nil {
+		displayName = *r.Attributes.FileName
+	}
+	displayName = markdownEscapeRegexp.ReplaceAllString(displayName, "\\$1")
+	if isImage(n.attrs["type"]) {
+		return "![" + displayName + "](" + markdownLinkTarget(filename) + ")"
+	}
+	return "[" + displayName + "](" + markdownLinkTarget(filename) + ")"
+}
+
+// wrapInline puts delimiters around s, keeping surrounding whitespace outside
+// of the delimiters as Markdown requires.
+func wrapInline(s, delim string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	start := s[:strings.Index(s, trimmed)]
+	end := s[len(start)+len(trimmed):]
+	return start + delim + trimmed + delim + end
+}
+
+func markdownLinkTarget(target string) string {
+	if strings.ContainsAny(target, " ()<>") {
+		return "<" + strings.Replace(target, ">", "%3E", -1) + ">"
+	}
+	return target
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+func TestConvertToMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		enml     string
+		expected string
+	}{
+		{"heading", `<en-note><h2>Title</h2><div>Text</div></en-note>`, "## Title\n\nText\n"},
+		{"emphasis", `<en-note><div>a <b>bold</b> and <i>italic</i> 2*3</div></en-note>`, "a **bold** and *italic* 2\\*3\n"},
+		{"link", `<en-note><div><a href="https://example.com">Example</a></div></en-note>`, "[Example](https://example.com)\n"},
+		{"list", `<en-note><ul><li>one</li><li>two<ol><li>nested</li></ol></li></ul></en-note>`, "- one\n- two\n  1. nested\n"},
+		{"todo", `<en-note><div><en-todo checked="true"/>done</div><div><en-todo/>open</div></en-note>`, "- [x] done\n\n- [ ] open\n"},
+		{"table", `<en-note><table><tr><td>a</td><td>b</td></tr><tr><td>c|d</td><td></td></tr></table></en-note>`, "| a | b |\n| --- | --- |\n| c\\|d |  |\n"},
+		{"line break", `<en-note><div>one<br/>two</div></en-note>`, "one\\\ntwo\n"},
+		{"entity", `<en-note><div>a&nbsp;b</div></en-note>`, "a\u00a0b\n"},
+		{"encrypted", `<en-note><div><en-crypt hint="pet" cipher="AES" length="128">c2Vj
+cmV0</en-crypt></div></en-note>`, "<en-crypt cipher=\"AES\" length=\"128\" hint=\"pet\">c2VjcmV0</en-crypt>\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := enmlHeader + test.enml
+			note := noteWithResources{note: &edam.Note{Content: &content}}
+			b := bytes.Buffer{}
+			if err := note.convertToMarkdown(&b); err != nil {
+				t.Fatal(err)
+			}
+			got := b.String()
+			got = got[strings.Index(got, "---\n\n")+5:]
+			if got != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestMarkdownFrontMatter(t *testing.T) {
+	guid := edam.GUID("1234")
+	title := `Say "hi"`
+	created := edam.Timestamp(1564660800000)
+	note := noteWithResources{note: &edam.Note{GUID: &guid, Title: &title, Created: &created, TagNames: []string{"x"}}}
+	expected := "---\nguid: \"1234\"\ntitle: \"Say \\\"hi\\\"\"\ntags: [\"x\"]\ncreated: \"2019-08-01T12:00:00Z\"\n---\n\n"
+	if got := note.markdownFrontMatter(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestWikiLinks(t *testing.T) {
+	content := enmlHeader + `<en-note><div><a href="evernote:///view/123/s1/0b3c1a2e-1111-2222-3333-444455556666/0b3c1a2e-1111-2222-3333-444455556666/">see here</a> and <a href="https://www.evernote.com/shard/s1/nl/123/aaaaaaaa-1111-2222-3333-444455556666/">unknown</a></div></en-note>`
+	note := noteWithResources{
+		note:   &edam.Note{Content: &content},
+		linked: map[string]string{"0b3c1a2e-1111-2222-3333-444455556666": "Other: Note"},
+	}
+	m := markdownConverter{note: note, wikiLinks: true}
+	got, err := m.convert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[[Other_ Note|see here]] and [unknown](https://www.evernote.com/shard/s1/nl/123/aaaaaaaa-1111-2222-3333-444455556666/)\n"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
@@ -20,20 +20,62 @@
 package main
 
 import (
+	"context"
 	"log"
+	gosync "sync"
 	"time"
 
 	"github.com/asig/duplikator/edam"
 )
 
+// rateLimit is the deadline until which no calls must be made to Evernote. It
+// is shared by all goroutines, so that one of them hitting the rate limit
+// pauses all of them.
+type rateLimit struct {
+	mu    gosync.Mutex
+	until time.Time
+}
+
+var throttle rateLimit
+
+// wait blocks until the rate limit has passed. It returns early with an error
+// if ctx is cancelled or duplikator is interrupted in the meantime.
+func (r *rateLimit) wait(ctx context.Context) error {
+	r.mu.Lock()
+	d := time.Until(r.until)
+	r.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-runContext.Done():
+		return runContext.Err()
+	}
+}
+
+func (r *rateLimit) hit(seconds int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until := time.Now().Add(time.Duration(seconds+1) * time.Second)
+	if until.After(r.until) {
+		log.Printf("Rate limit reached: Sleeping for %d seconds.", seconds)
+		r.until = until
+	}
+}
+
 func maybeThrottle(err error) bool {
 	if e, ok := err.(*edam.EDAMSystemException); ok {
 		if e.ErrorCode == edam.EDAMErrorCode_RATE_LIMIT_REACHED {
-			log.Printf("Rate limit reached: Sleeping for %d seconds.", e.GetRateLimitDuration())
-			time.Sleep(time.Duration(e.GetRateLimitDuration()+1) * time.Second)
+			// The callers wait for the deadline before retrying.
+			throttle.hit(e.GetRateLimitDuration())
 			return true
 		}
 	}
 	return false
 }
-
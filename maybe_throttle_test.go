@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitIsInterrupted(t *testing.T) {
+	defer func(ctx context.Context) { runContext = ctx }(runContext)
+	ctx, cancel := context.WithCancel(context.Background())
+	runContext = ctx
+
+	r := &rateLimit{}
+	r.hit(60)
+	cancel()
+	start := time.Now()
+	if err := r.wait(context.Background()); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("wait took %s", d)
+	}
+}
+
+func TestWaitIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &rateLimit{}
+	r.hit(60)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if err := r.wait(ctx); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("wait took %s", d)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+)
+
+// noteMetadataFileName is the sidecar file next to a note's content that
+// holds all of its metadata.
+const noteMetadataFileName = "note.json"
+
+// noteMetadata is what gets written to note.json. Note is the complete note
+// as returned by the server, including its ENML content, but without the
+// resources' binary data; these are stored in files/ and listed in
+// Resources. Timestamps are milliseconds since the epoch, as in the API.
+type noteMetadata struct {
+	Note      *edam.Note         `json:"note"`
+	Resources []resourceMetadata `json:"resources"`
+}
+
+type resourceMetadata struct {
+	GUID     string `json:"guid"`
+	Mime     string `json:"mime"`
+	Size     int32  `json:"size"`
+	MD5      string `json:"md5"`
+	FileName string `json:"fileName"`
+	// Path is the attachment file, relative to the note's directory.
+	Path string `json:"path"`
+	// Recognition, OCR and HOCR are the files with the text Evernote
+	// recognized in the attachment, if there is any: the recognition data
+	// as it is, the plain text and the text as hOCR.
+	Recognition string `json:"recognition,omitempty"`
+	OCR         string `json:"ocr,omitempty"`
+	HOCR        string `json:"hocr,omitempty"`
+}
+
+func (note noteWithResources) metadata() noteMetadata {
+	n := *note.note
+	n.Resources = nil
+	for _, r := range note.note.Resources {
+		n.Resources = append(n.Resources, withoutData(r))
+	}
+
+	res := []resourceMetadata{}
+	for hash, r := range note.resources {
+		m := resourceMetadata{
+			GUID:     string(r.GetGUID()),
+			Mime:     r.GetMime(),
+			Size:     r.GetData().GetSize(),
+			MD5:      hash,
+			FileName: r.GetAttributes().GetFileName(),
+			Path:     filepath.ToSlash(note.attachmentFileName(hash, true)),
+		}
+		if hasRecognition(r) {
+			m.Recognition = m.Path + recognitionSuffix
+		}
+		if hasRecognizedText(r) {
+			m.OCR = m.Path + ocrSuffix
+		}
+		if writesHocr(r) {
+			m.HOCR = m.Path + hocrSuffix
+		}
+		res = append(res, m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GUID < res[j].GUID })
+	return noteMetadata{Note: &n, Resources: res}
+}
+
+// withoutData returns a copy of the resource that keeps size and hash of its
+// data, but not the data itself.
+func withoutData(r *edam.Resource) *edam.Resource {
+	c := *r
+	strip := func(d *edam.Data) *edam.Data {
+		if d == nil {
+			return nil
+		}
+		return &edam.Data{BodyHash: d.BodyHash, Size: d.Size}
+	}
+	c.Data = strip(r.Data)
+	c.Recognition = strip(r.Recognition)
+	c.AlternateData = strip(r.AlternateData)
+	return &c
+}
+
+func (note noteWithResources) writeMetadata(filename string) error {
+	b, err := json.MarshalIndent(note.metadata(), "", " ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(filename, b, 0644)
+}
+
+// readNoteMetadata reads a note.json file.
+func readNoteMetadata(filename string) (noteMetadata, error) {
+	var m noteMetadata
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
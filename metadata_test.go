@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+func TestNoteMetadata(t *testing.T) {
+	title := "Hello"
+	guid := edam.GUID("note-guid")
+	resGUID := edam.GUID("res-guid")
+	mimeType := "text/plain"
+	fileName := "hello.txt"
+	size := int32(4)
+	res := &edam.Resource{
+		GUID:       &resGUID,
+		Mime:       &mimeType,
+		Data:       &edam.Data{Body: []byte("data"), BodyHash: []byte{0xca, 0xfe}, Size: &size},
+		Attributes: &edam.ResourceAttributes{FileName: &fileName},
+	}
+	note := noteWithResources{
+		note:      &edam.Note{GUID: &guid, Title: &title, Resources: []*edam.Resource{res}},
+		resources: map[string]*edam.Resource{"cafe": res},
+	}
+
+	m := note.metadata()
+	if got := m.Note.Resources[0].Data; got.Body != nil || got.Size == nil || *got.Size != 4 {
+		t.Errorf("Expected resource data without body, got %+v", got)
+	}
+	if res.Data.Body == nil {
+		t.Errorf("Original resource was modified")
+	}
+	expected := resourceMetadata{GUID: "res-guid", Mime: "text/plain", Size: 4, MD5: "cafe", FileName: "hello.txt", Path: "files/hello.txt"}
+	if len(m.Resources) != 1 || m.Resources[0] != expected {
+		t.Errorf("Expected resources [%+v], got %+v", expected, m.Resources)
+	}
+}
@@ -0,0 +1,392 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/tokenstore"
+)
+
+var (
+	migrateTokenStoreFlag = flag.String("migrate_token_store", "", "Token store of the account the 'migrate' command copies notes to")
+	migrateNotebooksFlag  = flag.String("migrate_notebooks", "", "Comma separated list of notebooks to migrate. All notebooks are migrated if empty.")
+	migrationFileFlag     = flag.String("migration_file", "duplikator-migration.json", "File that maps GUIDs of migrated notes, notebooks and tags to their GUIDs in the destination account")
+
+	noteLinkRegexp = regexp.MustCompile(`evernote:///view/\d+/[^/"]+/([0-9a-f-]+)/[0-9a-f-]+/?|https?://[^/"]+/shard/[^/"]+/nl/\d+/([0-9a-f-]+)/?`)
+)
+
+// migrationMap maps GUIDs in the source account to GUIDs in the destination
+// account.
+type migrationMap struct {
+	Notebooks map[string]string       `json:"notebooks"`
+	Tags      map[string]string       `json:"tags"`
+	Notes     map[string]migratedNote `json:"notes"`
+}
+
+type migratedNote struct {
+	GUID string `json:"guid"`
+	// USN is the update sequence number of the note in the source account
+	// when it was migrated.
+	USN int32 `json:"usn"`
+	// PendingLinks is set if the note links to notes that were not migrated
+	// yet.
+	PendingLinks bool `json:"pendingLinks,omitempty"`
+}
+
+func loadMigrationMap(filename string) (*migrationMap, error) {
+	m := &migrationMap{
+		Notebooks: make(map[string]string),
+		Tags:      make(map[string]string),
+		Notes:     make(map[string]migratedNote),
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(b, m)
+}
+
+func (m *migrationMap) save(filename string) error {
+	b, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(filename, b, 0644)
+}
+
+// migrator copies notes from the account duplikator is logged in to into the
+// account given by --migrate_token_store.
+type migrator struct {
+	ctx      context.Context
+	src, dst edam.NoteStore
+	srcToken string
+	dstToken string
+	dstUser  *edam.User
+	m        *migrationMap
+
+	srcTags map[string]*edam.Tag
+	// dstNotebooks and dstTags map lower case names to GUIDs in the
+	// destination account, dstGUIDs contains all their GUIDs.
+	dstNotebooks map[string]string
+	dstTags      map[string]string
+	dstGUIDs     map[string]bool
+}
+
+func migrate() error {
+	if *migrateTokenStoreFlag == "" {
+		return errors.New("'migrate' needs --migrate_token_store")
+	}
+	ctx := context.Background()
+	store, err := tokenstore.Open(*migrateTokenStoreFlag)
+	if err != nil {
+		return err
+	}
+	dstClient := newEvernoteClient(environment())
+	if err := dstClient.authenticate(store); err != nil {
+		return err
+	}
+	dstNs, err := dstClient.getNoteStore(ctx)
+	if err != nil {
+		return err
+	}
+	us, err := dstClient.getUserStore()
+	if err != nil {
+		return err
+	}
+	dstUser, err := us.GetUser(ctx, dstClient.authToken)
+	if err != nil {
+		return err
+	}
+	m, err := loadMigrationMap(*migrationFileFlag)
+	if err != nil {
+		return err
+	}
+
+	mig := &migrator{
+		ctx:      ctx,
+		src:      ns,
+		dst:      dstNs,
+		srcToken: client.authToken,
+		dstToken: dstClient.authToken,
+		dstUser:  dstUser,
+		m:        m,
+	}
+	if err := mig.init(); err != nil {
+		return err
+	}
+	return mig.run()
+}
+
+func (mig *migrator) init() error {
+	tags, err := mig.src.ListTags(mig.ctx, mig.srcToken)
+	if err != nil {
+		return err
+	}
+	mig.srcTags = make(map[string]*edam.Tag)
+	for _, t := range tags {
+		mig.srcTags[string(t.GetGUID())] = t
+	}
+
+	mig.dstNotebooks = make(map[string]string)
+	mig.dstTags = make(map[string]string)
+	mig.dstGUIDs = make(map[string]bool)
+	notebooks, err := mig.dst.ListNotebooks(mig.ctx, mig.dstToken)
+	if err != nil {
+		return err
+	}
+	for _, nb := range notebooks {
+		mig.dstNotebooks[strings.ToLower(nb.GetName())] = string(nb.GetGUID())
+		mig.dstGUIDs[string(nb.GetGUID())] = true
+	}
+	tags, err = mig.dst.ListTags(mig.ctx, mig.dstToken)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		mig.dstTags[strings.ToLower(t.GetName())] = string(t.GetGUID())
+		mig.dstGUIDs[string(t.GetGUID())] = true
+	}
+	return nil
+}
+
+func (mig *migrator) run() error {
+	notebooks, err := mig.selectedNotebooks()
+	if err != nil {
+		return err
+	}
+	for _, nb := range notebooks {
+		dstNotebook, err := mig.notebookGUID(nb)
+		if err != nil {
+			return err
+		}
+		filter := edam.NewNoteFilter()
+		filter.NotebookGuid = nb.GUID
+		notes, err := findNotesMetadata(mig.ctx, mig.src, mig.srcToken, filter)
+		if err != nil {
+			return err
+		}
+		log.Printf("Migrating notebook %q (%d notes)", nb.GetName(), len(notes))
+		for _, meta := range notes {
+			if err := mig.migrateNote(meta, dstNotebook); err != nil {
+				return err
+			}
+			if err := mig.m.save(*migrationFileFlag); err != nil {
+				return err
+			}
+		}
+	}
+	return mig.fixPendingLinks()
+}
+
+func (mig *migrator) selectedNotebooks() ([]*edam.Notebook, error) {
+	notebooks, err := mig.src.ListNotebooks(mig.ctx, mig.srcToken)
+	if err != nil {
+		return nil, err
+	}
+	if *migrateNotebooksFlag == "" {
+		return notebooks, nil
+	}
+	byName := make(map[string]*edam.Notebook)
+	for _, nb := range notebooks {
+		byName[nb.GetName()] = nb
+	}
+	res := []*edam.Notebook{}
+	for _, name := range strings.Split(*migrateNotebooksFlag, ",") {
+		nb, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("notebook %q does not exist", name)
+		}
+		res = append(res, nb)
+	}
+	return res, nil
+}
+
+// notebookGUID returns the GUID of the destination notebook for a source
+// notebook. Notebooks are matched by name and created if necessary.
+func (mig *migrator) notebookGUID(nb *edam.Notebook) (string, error) {
+	if guid, ok := mig.m.Notebooks[string(nb.GetGUID())]; ok && mig.dstGUIDs[guid] {
+		return guid, nil
+	}
+	guid, ok := mig.dstNotebooks[strings.ToLower(nb.GetName())]
+	if !ok {
+		created, err := mig.dst.CreateNotebook(mig.ctx, mig.dstToken, &edam.Notebook{Name: nb.Name, Stack: nb.Stack})
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Created notebook %q", nb.GetName())
+		guid = string(created.GetGUID())
+		mig.dstNotebooks[strings.ToLower(nb.GetName())] = guid
+		mig.dstGUIDs[guid] = true
+	}
+	mig.m.Notebooks[string(nb.GetGUID())] = guid
+	return guid, nil
+}
+
+// tagGUID returns the GUID of the destination tag for a source tag. Tags are
+// matched by name and created below the same parent if necessary.
+func (mig *migrator) tagGUID(srcGUID string, seen map[string]bool) (string, error) {
+	if guid, ok := mig.m.Tags[srcGUID]; ok && mig.dstGUIDs[guid] {
+		return guid, nil
+	}
+	t, ok := mig.srcTags[srcGUID]
+	if !ok {
+		return "", fmt.Errorf("unknown tag %s", srcGUID)
+	}
+	seen[srcGUID] = true
+	guid, ok := mig.dstTags[strings.ToLower(t.GetName())]
+	if !ok {
+		tag := &edam.Tag{Name: t.Name}
+		if parent := string(t.GetParentGuid()); parent != "" && !seen[parent] {
+			parentGUID, err := mig.tagGUID(parent, seen)
+			if err != nil {
+				return "", err
+			}
+			tag.ParentGuid = (*edam.GUID)(&parentGUID)
+		}
+		created, err := mig.dst.CreateTag(mig.ctx, mig.dstToken, tag)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Created tag %q", t.GetName())
+		guid = string(created.GetGUID())
+		mig.dstTags[strings.ToLower(t.GetName())] = guid
+		mig.dstGUIDs[guid] = true
+	}
+	mig.m.Tags[srcGUID] = guid
+	return guid, nil
+}
+
+// migrateNote copies a note to the destination account, unless it didn't
+// change since the last migration. Notes that were migrated before are
+// updated in place.
+func (mig *migrator) migrateNote(meta *edam.NoteMetadata, dstNotebook string) error {
+	srcGUID := string(meta.GUID)
+	prev, migrated := mig.m.Notes[srcGUID]
+	if migrated && prev.USN == meta.GetUpdateSequenceNum() {
+		return nil
+	}
+
+	note, err := mig.src.GetNoteWithResultSpec(mig.ctx, mig.srcToken, meta.GUID, &edam.NoteResultSpec{
+		IncludeContent:       boolVal(true),
+		IncludeResourcesData: boolVal(true),
+	})
+	if err != nil {
+		return err
+	}
+	upload := noteForUpload(note)
+	upload.NotebookGuid = &dstNotebook
+	for _, tagGUID := range note.TagGuids {
+		guid, err := mig.tagGUID(string(tagGUID), make(map[string]bool))
+		if err != nil {
+			return err
+		}
+		upload.TagGuids = append(upload.TagGuids, edam.GUID(guid))
+	}
+	content, complete := mig.rewriteNoteLinks(note.GetContent())
+	upload.Content = &content
+
+	var res *edam.Note
+	if migrated {
+		upload.GUID = (*edam.GUID)(&prev.GUID)
+		res, err = mig.dst.UpdateNote(mig.ctx, mig.dstToken, upload)
+		if _, ok := err.(*edam.EDAMNotFoundException); ok {
+			// Deleted in the destination account, migrate it again.
+			upload.GUID = nil
+			migrated = false
+		}
+	}
+	if !migrated {
+		res, err = mig.dst.CreateNote(mig.ctx, mig.dstToken, upload)
+	}
+	if err != nil {
+		return fmt.Errorf("can't migrate note %q: %s", note.GetTitle(), err)
+	}
+	log.Printf("Migrated note %q", note.GetTitle())
+	mig.m.Notes[srcGUID] = migratedNote{GUID: string(res.GetGUID()), USN: note.GetUpdateSequenceNum(), PendingLinks: !complete}
+	return nil
+}
+
+// rewriteNoteLinks makes links to migrated notes point to the destination
+// account. It returns false if there are links to notes of the source
+// account that were not migrated yet. Links that already point to migrated
+// notes in the destination account are left alone.
+func (mig *migrator) rewriteNoteLinks(content string) (string, bool) {
+	var migrated map[string]bool
+	complete := true
+	res := noteLinkRegexp.ReplaceAllStringFunc(content, func(link string) string {
+		m := noteLinkRegexp.FindStringSubmatch(link)
+		guid := m[1] + m[2]
+		if mapped, ok := mig.m.Notes[guid]; ok {
+			return fmt.Sprintf("evernote:///view/%d/%s/%s/%s/", mig.dstUser.GetID(), mig.dstUser.GetShardId(), mapped.GUID, mapped.GUID)
+		}
+		if migrated == nil {
+			migrated = make(map[string]bool)
+			for _, n := range mig.m.Notes {
+				migrated[n.GUID] = true
+			}
+		}
+		if !migrated[guid] {
+			complete = false
+		}
+		return link
+	})
+	return res, complete
+}
+
+// fixPendingLinks rewrites links in migrated notes that point to notes that
+// were migrated after them.
+func (mig *migrator) fixPendingLinks() error {
+	for srcGUID, migrated := range mig.m.Notes {
+		if !migrated.PendingLinks {
+			continue
+		}
+		guid := edam.GUID(migrated.GUID)
+		note, err := mig.dst.GetNoteWithResultSpec(mig.ctx, mig.dstToken, guid, &edam.NoteResultSpec{IncludeContent: boolVal(true)})
+		if err != nil {
+			return err
+		}
+		content, complete := mig.rewriteNoteLinks(note.GetContent())
+		if content != note.GetContent() {
+			// Without Resources, UpdateNote leaves the note's resources alone.
+			_, err = mig.dst.UpdateNote(mig.ctx, mig.dstToken, &edam.Note{GUID: &guid, Title: note.Title, Content: &content})
+			if err != nil {
+				return err
+			}
+		}
+		migrated.PendingLinks = !complete
+		mig.m.Notes[srcGUID] = migrated
+	}
+	return mig.m.save(*migrationFileFlag)
+}
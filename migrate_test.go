@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+func TestRewriteNoteLinks(t *testing.T) {
+	id := edam.UserID(42)
+	shard := "s7"
+	mig := &migrator{
+		dstUser: &edam.User{ID: &id, ShardId: &shard},
+		m: &migrationMap{Notes: map[string]migratedNote{
+			"0b3c1a2e-1111-2222-3333-444455556666": {GUID: "ffffffff-1111-2222-3333-444455556666"},
+		}},
+	}
+	content := `<a href="evernote:///view/123/s1/0b3c1a2e-1111-2222-3333-444455556666/0b3c1a2e-1111-2222-3333-444455556666/">a</a>` +
+		`<a href="https://www.evernote.com/shard/s1/nl/123/0b3c1a2e-1111-2222-3333-444455556666/">b</a>`
+	expected := `<a href="evernote:///view/42/s7/ffffffff-1111-2222-3333-444455556666/ffffffff-1111-2222-3333-444455556666/">a</a>` +
+		`<a href="evernote:///view/42/s7/ffffffff-1111-2222-3333-444455556666/ffffffff-1111-2222-3333-444455556666/">b</a>`
+	res, complete := mig.rewriteNoteLinks(content)
+	if res != expected || !complete {
+		t.Errorf("Expected %q, got %q (complete = %v)", expected, res, complete)
+	}
+	// Links that were rewritten before are complete, too
+	if res, complete := mig.rewriteNoteLinks(expected); res != expected || !complete {
+		t.Errorf("Expected %q, got %q (complete = %v)", expected, res, complete)
+	}
+
+	unknown := `<a href="evernote:///view/123/s1/aaaaaaaa-1111-2222-3333-444455556666/aaaaaaaa-1111-2222-3333-444455556666/">c</a>`
+	if res, complete := mig.rewriteNoteLinks(unknown); res != unknown || complete {
+		t.Errorf("Expected unknown link to be kept, got %q (complete = %v)", res, complete)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/asig/duplikator/repository"
+)
+
+// noteDirRegexp matches the directories the notebook layout writes notes to:
+// the title followed by the note's GUID.
+var noteDirRegexp = regexp.MustCompile(`-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// findOrphans returns the notes below destDir that no entry of the
+// repository owns, e.g. directories left behind by older versions that didn't
+// move notes when their title changed. The reserved directories at the top
+// level hold snapshots, linked notebooks and the like and are skipped.
+func findOrphans(destDir string, repo *repository.Repo, layout noteLayout) ([]string, error) {
+	owned := make(map[string]bool)
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		owned[layout.path(e)] = true
+	}
+
+	reserved := make(map[string]bool)
+	for _, name := range reservedDirNames {
+		reserved[name] = true
+	}
+	_, obsidian := layout.(obsidianLayout)
+	var orphans []string
+	err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == destDir {
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() && filepath.Dir(path) == destDir && reserved[name] {
+			return filepath.SkipDir
+		}
+		switch {
+		case owned[path]:
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		case obsidian && !info.IsDir() && strings.HasSuffix(name, ".md"):
+			orphans = append(orphans, path)
+		case !obsidian && info.IsDir() && noteDirRegexp.MatchString(name):
+			orphans = append(orphans, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	sort.Strings(orphans)
+	return orphans, err
+}
+
+// listOrphans prints the orphaned notes of the backup and of all linked
+// notebooks in it.
+func listOrphans() error {
+	destDir := *destDirFlag
+	dirs := []string{destDir}
+	linked, _ := filepath.Glob(filepath.Join(destDir, linkedDirName, "*"))
+	dirs = append(dirs, linked...)
+
+	for _, dir := range dirs {
+		repo, err := repository.Load(dir)
+		if err != nil {
+			return err
+		}
+		layout, err := newNoteLayout(dir, repo)
+		if err != nil {
+			return err
+		}
+		orphans, err := findOrphans(dir, repo, layout)
+		if err != nil {
+			return err
+		}
+		for _, o := range orphans {
+			fmt.Println(o)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asig/duplikator/repository"
+)
+
+func TestFindOrphans(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "orphans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	e := repo.GetOrAdd("0c9d9a59-ff1c-4c32-b3a8-11b3f9ed9f3a")
+	e.Title = "Hello"
+	layout := notebookLayout{destDir, repo}
+	if err := os.MkdirAll(filepath.Join(layout.noteDir(e), versionsDirName, "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The note was renamed by an older version that left its directory
+	// behind.
+	stale := baseName(destDir, "Old title", e.GUID)
+	// Notebooks starting with "_" are scanned, too
+	inbox := baseName(notebookDir(destDir, &repository.Notebook{Name: "_Inbox"}), "Lost", e.GUID)
+	for _, dir := range []string{stale, inbox, filepath.Join(destDir, expungedDirName, "x", filepath.Base(stale))} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphans, err := findOrphans(destDir, repo, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 2 || orphans[0] != stale || orphans[1] != inbox {
+		t.Errorf("Expected [%s %s], got %v", stale, inbox, orphans)
+	}
+
+	// Recorded paths take precedence over title and notebook.
+	recordPath(destDir, layout, e)
+	e.Title = "New title"
+	if got := layout.noteDir(e); got != baseName(destDir, "Hello", e.GUID) {
+		t.Errorf("Expected recorded path, got %s", got)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+)
+
+// Suffixes of the files written next to an attachment for its recognition
+// data.
+const (
+	recognitionSuffix = ".reco.xml"
+	ocrSuffix         = ".ocr.txt"
+	hocrSuffix        = ".hocr"
+)
+
+var hocrFlag = flag.Bool("hocr", false, "Also write the text Evernote recognized in images as hOCR, next to the image")
+
+// recoIndex is the recognition data of a resource. Every item is a region
+// of the image with candidates for the text in it.
+type recoIndex struct {
+	ObjType string     `xml:"objType,attr"`
+	Width   int        `xml:"objWidth,attr"`
+	Height  int        `xml:"objHeight,attr"`
+	Items   []recoItem `xml:"item"`
+}
+
+type recoItem struct {
+	X          int             `xml:"x,attr"`
+	Y          int             `xml:"y,attr"`
+	W          int             `xml:"w,attr"`
+	H          int             `xml:"h,attr"`
+	Candidates []recoCandidate `xml:"t"`
+}
+
+type recoCandidate struct {
+	Weight int    `xml:"w,attr"`
+	Text   string `xml:",chardata"`
+}
+
+// text returns the candidate with the highest weight.
+func (i recoItem) text() string {
+	best := recoCandidate{Weight: -1}
+	for _, c := range i.Candidates {
+		if c.Weight > best.Weight {
+			best = c
+		}
+	}
+	return strings.TrimSpace(best.Text)
+}
+
+func parseRecoIndex(b []byte) (*recoIndex, error) {
+	res := &recoIndex{}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	err := d.Decode(res)
+	return res, err
+}
+
+// lines groups the items with text into lines: an item belongs to a line if
+// its vertical center lies within the line. Lines are ordered top to
+// bottom, their items left to right.
+func (r *recoIndex) lines() [][]recoItem {
+	items := []recoItem{}
+	for _, i := range r.Items {
+		if i.text() != "" {
+			items = append(items, i)
+		}
+	}
+	sort.SliceStable(items, func(a, b int) bool { return items[a].Y < items[b].Y })
+
+	var res [][]recoItem
+	top, bottom := 0, -1
+	for _, i := range items {
+		center := i.Y + i.H/2
+		if len(res) == 0 || center < top || center > bottom {
+			res = append(res, nil)
+			top, bottom = i.Y, i.Y+i.H
+		}
+		res[len(res)-1] = append(res[len(res)-1], i)
+	}
+	for _, line := range res {
+		sort.SliceStable(line, func(a, b int) bool { return line[a].X < line[b].X })
+	}
+	return res
+}
+
+// text returns the recognized text, one line per line of the image.
+func (r *recoIndex) text() string {
+	var b strings.Builder
+	for _, line := range r.lines() {
+		words := []string{}
+		for _, i := range line {
+			words = append(words, i.text())
+		}
+		b.WriteString(strings.Join(words, " ") + "\n")
+	}
+	return b.String()
+}
+
+// hocr returns the recognized text as hOCR, so that it can be overlaid on
+// the image.
+func (r *recoIndex) hocr(title string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<meta name="ocr-system" content="Evernote"/>
+<meta name="ocr-capabilities" content="ocr_page ocr_line ocrx_word"/>
+<title>` + html.EscapeString(title) + `</title>
+</head>
+<body>
+`)
+	fmt.Fprintf(&b, "<div class=\"ocr_page\" title=\"bbox 0 0 %d %d\">\n", r.Width, r.Height)
+	for _, line := range r.lines() {
+		x0, y0, x1, y1 := line[0].X, line[0].Y, 0, 0
+		for _, i := range line {
+			if i.Y < y0 {
+				y0 = i.Y
+			}
+			if i.X+i.W > x1 {
+				x1 = i.X + i.W
+			}
+			if i.Y+i.H > y1 {
+				y1 = i.Y + i.H
+			}
+		}
+		fmt.Fprintf(&b, "<span class=\"ocr_line\" title=\"bbox %d %d %d %d\">", x0, y0, x1, y1)
+		for n, i := range line {
+			if n > 0 {
+				b.WriteString(" ")
+			}
+			fmt.Fprintf(&b, "<span class=\"ocrx_word\" title=\"bbox %d %d %d %d\">%s</span>", i.X, i.Y, i.X+i.W, i.Y+i.H, html.EscapeString(i.text()))
+		}
+		b.WriteString("</span>\n")
+	}
+	b.WriteString("</div>\n</body>\n</html>\n")
+	return b.String()
+}
+
+func hasRecognition(res *edam.Resource) bool {
+	return res.Recognition != nil && len(res.Recognition.Body) > 0
+}
+
+// hasRecognizedText tells whether the text files are written for the
+// resource, i.e. whether its recognition data can be parsed.
+func hasRecognizedText(res *edam.Resource) bool {
+	if !hasRecognition(res) {
+		return false
+	}
+	_, err := parseRecoIndex(res.Recognition.Body)
+	return err == nil
+}
+
+// writesHocr tells whether an hOCR file is written for the resource.
+func writesHocr(res *edam.Resource) bool {
+	return *hocrFlag && isImage(res.GetMime()) && hasRecognizedText(res)
+}
+
+// writeRecognition writes the recognition data of a resource next to its
+// attachment file: the data as it is, the recognized text and, if
+// requested, the text as hOCR. If the data can't be parsed, only the data
+// itself is written.
+func writeRecognition(res *edam.Resource, attachment string) error {
+	if !hasRecognition(res) {
+		return nil
+	}
+	body := res.Recognition.Body
+	if err := fileutil.WriteFile(attachment+recognitionSuffix, body, 0644); err != nil {
+		return err
+	}
+	reco, err := parseRecoIndex(body)
+	if err != nil {
+		// The text is only an extra, don't fail the backup of the note
+		log.Printf("Can't parse recognition data of %s, skipping its text: %s", attachment, err)
+		return nil
+	}
+	if err := fileutil.WriteFile(attachment+ocrSuffix, []byte(reco.text()), 0644); err != nil {
+		return err
+	}
+	if writesHocr(res) {
+		return fileutil.WriteFile(attachment+hocrSuffix, []byte(reco.hocr(filepath.Base(attachment))), 0644)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+const recoIndexXML = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE recoIndex PUBLIC "SYSTEM" "http://xml.evernote.com/pub/recoIndex.dtd">
+<recoIndex docType="unknown" objType="image" objID="a284273e482578224145f2560b67bf45" engineVersion="3.0.17.14" recoType="service" lang="en" objWidth="640" objHeight="480">
+<item x="300" y="12" w="80" h="20"><t w="71">WORLD</t><t w="32">W0RLD</t></item>
+<item x="10" y="10" w="100" h="24"><t w="40">HELL0</t><t w="87">HELLO</t></item>
+<item x="10" y="60" w="90" h="20"><t w="75">Total</t></item>
+<item x="200" y="62" w="50" h="18"><t w="80">&lt;42&gt;</t></item>
+</recoIndex>`
+
+func TestRecognitionText(t *testing.T) {
+	reco, err := parseRecoIndex([]byte(recoIndexXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := reco.text(), "HELLO WORLD\nTotal <42>\n"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	hocr := reco.hocr("scan.png")
+	for _, s := range []string{
+		`<div class="ocr_page" title="bbox 0 0 640 480">`,
+		`<span class="ocr_line" title="bbox 10 10 380 34">`,
+		`<span class="ocrx_word" title="bbox 200 62 250 80">&lt;42&gt;</span>`,
+	} {
+		if !strings.Contains(hocr, s) {
+			t.Errorf("Expected %s in %s", s, hocr)
+		}
+	}
+}
+
+func TestWriteRecognition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recognition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(b bool) { *hocrFlag = b }(*hocrFlag)
+	*hocrFlag = true
+	mime := "image/png"
+	res := &edam.Resource{Mime: &mime, Recognition: &edam.Data{Body: []byte(recoIndexXML)}}
+	attachment := filepath.Join(dir, "scan.png")
+	if err := writeRecognition(res, attachment); err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{recognitionSuffix, ocrSuffix, hocrSuffix} {
+		if _, err := os.Stat(attachment + suffix); err != nil {
+			t.Errorf("%s was not written: %s", suffix, err)
+		}
+	}
+
+	// Data that can't be parsed is kept, but doesn't fail the note.
+	res.Recognition.Body = []byte("<recoIndex><item")
+	attachment = filepath.Join(dir, "broken.png")
+	if err := writeRecognition(res, attachment); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(attachment + recognitionSuffix); err != nil {
+		t.Errorf("Recognition data was not written: %s", err)
+	}
+	for _, suffix := range []string{ocrSuffix, hocrSuffix} {
+		if _, err := os.Stat(attachment + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s was written for broken recognition data", suffix)
+		}
+	}
+}
@@ -20,27 +20,118 @@
 package repository
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
+
+	"github.com/asig/duplikator/fileutil"
+)
+
+// SourceBusiness marks entries that were synced from the Evernote Business
+// note store. Entries of the user's own account have an empty source.
+const SourceBusiness = "business"
+
+// Reasons why a note left the backup.
+const (
+	// ReasonTrashed is used for notes that were moved to the trash.
+	ReasonTrashed = "trashed"
+	// ReasonExpunged is used for notes that were deleted for good.
+	ReasonExpunged = "expunged"
+	// ReasonMissing is used for notes that were not returned by a full sync
+	// anymore, e.g. because the notebook is no longer accessible.
+	ReasonMissing = "missing"
 )
 
 type Entry struct {
 	GUID string `json:"guid"`
 	UpdateSequenceNum int64 `json:"updated"`
 	Title string `json:"title"`
+	Source string `json:"source,omitempty"`
+	NotebookGUID string `json:"notebook,omitempty"`
+	// Tags are the names of the note's tags, TagGUIDs their GUIDs. Entries
+	// written before tag GUIDs were recorded only have the names.
+	Tags []string `json:"tags,omitempty"`
+	TagGUIDs []string `json:"tagGuids,omitempty"`
+	// Path is where the note is stored, relative to the repository's
+	// directory. It is empty for notes written before paths were recorded.
+	Path string `json:"path,omitempty"`
+	// Links are the GUIDs of the notes the note links to. It is nil for
+	// entries written before links were recorded, and empty for notes
+	// without links.
+	Links []string `json:"links"`
+	// Trashed is set for notes that are in the trash.
+	Trashed bool `json:"trashed,omitempty"`
+	// Departed is set for notes that are gone from the server, but are
+	// kept in the backup.
+	Departed *Departure `json:"departed,omitempty"`
+}
+
+// Departure records when and why a note left the server.
+type Departure struct {
+	GUID   string `json:"guid"`
+	Title  string `json:"title"`
+	// Time is when the note was found to be gone, in ms since the epoch.
+	Time   int64  `json:"time"`
+	Reason string `json:"reason"`
+	// Path is where the note was moved to, relative to the backup.
+	Path string `json:"path,omitempty"`
+}
+
+type Notebook struct {
+	GUID   string `json:"guid"`
+	Name   string `json:"name"`
+	Stack  string `json:"stack,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+type Tag struct {
+	GUID       string `json:"guid"`
+	Name       string `json:"name"`
+	ParentGUID string `json:"parent,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+// SyncState is the account's sync position as of the last successful sync.
+type SyncState struct {
+	// UpdateCount is the highest USN that has been processed.
+	UpdateCount int32 `json:"updateCount"`
+	// LastSync is the server time (ms since epoch) of the last sync.
+	LastSync int64 `json:"lastSync"`
 }
 
 type Repo struct {
     filename string
-	entries []Entry
+	state SyncState
+	sourceStates map[string]SyncState
+	entries map[string]*Entry
+	notebooks map[string]*Notebook
+	tags map[string]*Tag
+	departures []Departure
 };
 
+// repoFile is the on-disk representation of a Repo.
+type repoFile struct {
+	SyncState
+	Sources   map[string]SyncState `json:"sources,omitempty"`
+	Notebooks []*Notebook `json:"notebooks,omitempty"`
+	Tags      []*Tag      `json:"tags,omitempty"`
+	Entries   []*Entry    `json:"notes"`
+	Departures []Departure `json:"departures,omitempty"`
+}
+
 func New(baseDir string) *Repo {
 	name := path.Join(baseDir, "repository.json")
-	res := Repo{filename: name, entries: []Entry{} };
+	res := Repo{
+		filename:     name,
+		sourceStates: make(map[string]SyncState),
+		entries:      make(map[string]*Entry),
+		notebooks: make(map[string]*Notebook),
+		tags:      make(map[string]*Tag),
+	};
 	return &res
 }
 
@@ -58,45 +149,153 @@ func Load(baseDir string) (*Repo, error) {
 	defer jsonFile.Close()
 
 	byteValue, _ := ioutil.ReadAll(jsonFile)
-	err = json.Unmarshal(byteValue, &res.entries)
-	return res, err
+	rf := repoFile{}
+	if bytes.HasPrefix(bytes.TrimSpace(byteValue), []byte("[")) {
+		// Old repositories are a plain list of entries without a sync state.
+		err = json.Unmarshal(byteValue, &rf.Entries)
+	} else {
+		err = json.Unmarshal(byteValue, &rf)
+	}
+	if err != nil {
+		return res, err
+	}
+	res.state = rf.SyncState
+	for source, state := range rf.Sources {
+		res.sourceStates[source] = state
+	}
+	for _, e := range rf.Entries {
+		res.entries[e.GUID] = e
+	}
+	for _, nb := range rf.Notebooks {
+		res.notebooks[nb.GUID] = nb
+	}
+	for _, t := range rf.Tags {
+		res.tags[t.GUID] = t
+	}
+	res.departures = rf.Departures
+	return res, nil
 }
 
 func (r *Repo) Save() error {
 	log.Printf("Writing repository to %s", r.filename);
-	file, _ := json.MarshalIndent(r.entries, "", " ")
-	return ioutil.WriteFile(r.filename, file, 0644)
+	rf := repoFile{SyncState: r.state, Entries: []*Entry{}, Departures: r.departures}
+	if len(r.sourceStates) > 0 {
+		rf.Sources = r.sourceStates
+	}
+	for _, guid := range r.GUIDs() {
+		rf.Entries = append(rf.Entries, r.entries[guid])
+	}
+	for _, guid := range r.NotebookGUIDs() {
+		rf.Notebooks = append(rf.Notebooks, r.notebooks[guid])
+	}
+	for _, guid := range r.TagGUIDs() {
+		rf.Tags = append(rf.Tags, r.tags[guid])
+	}
+	file, _ := json.MarshalIndent(rf, "", " ")
+	return fileutil.WriteFile(r.filename, file, 0644)
 }
 
-func (r *Repo) Get(guid string) (entry *Entry, ok bool) {
-	for _, e := range r.entries {
-		if e.GUID == guid {
-			return &e, true
-		}
+// SyncState returns the sync state of the given source; "" is the user's
+// own account.
+func (r *Repo) SyncState(source string) SyncState {
+	if source == "" {
+		return r.state
+	}
+	return r.sourceStates[source]
+}
+
+func (r *Repo) SetSyncState(source string, state SyncState) {
+	if source == "" {
+		r.state = state
+		return
 	}
-	return nil, false
+	r.sourceStates[source] = state
+}
+
+func (r *Repo) Get(guid string) (entry *Entry, ok bool) {
+	entry, ok = r.entries[guid]
+	return entry, ok
 }
 
 func (r *Repo) GetOrAdd(guid string) *Entry {
 	if e, ok := r.Get(guid); ok {
 		return e
 	}
-	r.entries = append(r.entries, Entry{GUID: guid})
-	return &r.entries[len(r.entries) - 1]
+	e := &Entry{GUID: guid}
+	r.entries[guid] = e
+	return e
 }
 
 func (r *Repo) Add(entry *Entry) *Entry {
     e := r.GetOrAdd(entry.GUID)
-    e.GUID = entry.GUID
-    e.UpdateSequenceNum = entry.UpdateSequenceNum
-    e.Title = entry.Title
+    *e = *entry
     return e
 }
 
+func (r *Repo) Remove(guid string) {
+	delete(r.entries, guid)
+}
+
+// AddDeparture records that a note left the server.
+func (r *Repo) AddDeparture(d Departure) {
+	r.departures = append(r.departures, d)
+}
+
+// Departures returns all notes that left the server, in the order they
+// were recorded.
+func (r *Repo) Departures() []Departure {
+	return r.departures
+}
+
 func (r *Repo) GUIDs() []string {
-    res := []string{}
-	for _, e := range r.entries {
-	    res = append(res, e.GUID)
+	res := make([]string, 0, len(r.entries))
+	for guid := range r.entries {
+	    res = append(res, guid)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (r *Repo) Notebook(guid string) (notebook *Notebook, ok bool) {
+	notebook, ok = r.notebooks[guid]
+	return notebook, ok
+}
+
+func (r *Repo) PutNotebook(notebook *Notebook) {
+	r.notebooks[notebook.GUID] = notebook
+}
+
+func (r *Repo) RemoveNotebook(guid string) {
+	delete(r.notebooks, guid)
+}
+
+func (r *Repo) Tag(guid string) (tag *Tag, ok bool) {
+	tag, ok = r.tags[guid]
+	return tag, ok
+}
+
+func (r *Repo) PutTag(tag *Tag) {
+	r.tags[tag.GUID] = tag
+}
+
+func (r *Repo) RemoveTag(guid string) {
+	delete(r.tags, guid)
+}
+
+func (r *Repo) NotebookGUIDs() []string {
+	res := make([]string, 0, len(r.notebooks))
+	for guid := range r.notebooks {
+		res = append(res, guid)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (r *Repo) TagGUIDs() []string {
+	res := make([]string, 0, len(r.tags))
+	for guid := range r.tags {
+		res = append(res, guid)
 	}
+	sort.Strings(res)
 	return res
 }
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadLegacyFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	legacy := `[{"guid": "g1", "updated": 12, "title": "Hello"}]`
+	if err := ioutil.WriteFile(path.Join(dir, "repository.json"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := r.Get("g1")
+	if !ok {
+		t.Fatalf("Entry g1 not found")
+	}
+	if e.UpdateSequenceNum != 12 || e.Title != "Hello" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if r.SyncState("").UpdateCount != 0 {
+		t.Errorf("Expected empty sync state, got %+v", r.SyncState(""))
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := New(dir)
+	r.SetSyncState("", SyncState{UpdateCount: 42, LastSync: 1000})
+	r.GetOrAdd("g1").Title = "Hello"
+	r.PutNotebook(&Notebook{GUID: "nb", Name: "Notebook"})
+	r.GetOrAdd("g2").Trashed = true
+	r.AddDeparture(Departure{GUID: "g3", Title: "Gone", Time: 2000, Reason: ReasonExpunged})
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.SyncState("").UpdateCount != 42 || r.SyncState("").LastSync != 1000 {
+		t.Errorf("Unexpected sync state %+v", r.SyncState(""))
+	}
+	if e, ok := r.Get("g1"); !ok || e.Title != "Hello" {
+		t.Errorf("Entry g1 not restored: %+v", e)
+	}
+	if nb, ok := r.Notebook("nb"); !ok || nb.Name != "Notebook" {
+		t.Errorf("Notebook nb not restored: %+v", nb)
+	}
+	if e, ok := r.Get("g2"); !ok || !e.Trashed {
+		t.Errorf("Entry g2 not restored: %+v", e)
+	}
+	if d := r.Departures(); len(d) != 1 || d[0].GUID != "g3" || d[0].Reason != ReasonExpunged {
+		t.Errorf("Unexpected departures %+v", d)
+	}
+}
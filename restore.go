@@ -0,0 +1,501 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/repository"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	restoreNotebookFlag = flag.String("restore_notebook", "", "Only restore notes of the notebook with this name")
+	dryRunFlag          = flag.Bool("dry_run", false, "Only show what would be changed in the account")
+	restoreTrashedFlag  = flag.Bool("restore_trashed", false, "When restoring all notes, also restore notes that are in the trash or no longer in the account")
+)
+
+// restoredFileName is the file in --dest_dir that maps the GUIDs of restored
+// notes to the GUIDs of the notes created for them, so that running 'restore'
+// again doesn't create duplicates.
+const restoredFileName = "restored.json"
+
+// restorer uploads notes from a backup into the account. Notebooks and tags
+// are matched by name and created if they don't exist yet.
+type restorer struct {
+	ctx       context.Context
+	ns        edam.NoteStore
+	authToken string
+	repo      *repository.Repo
+	dryRun    bool
+
+	// notebooks and tags map lower case names to GUIDs in the account.
+	notebooks map[string]edam.GUID
+	tags      map[string]edam.GUID
+	// restored maps GUIDs of notes in the backup to the GUIDs of the notes
+	// created for them.
+	restored     map[string]string
+	restoredFile string
+}
+
+func restoreAll() error {
+	return restore(nil)
+}
+
+// restore uploads the notes with the given GUIDs from --dest_dir into the
+// account. Without GUIDs, all notes of the user's own account in the backup
+// are restored, except for trashed and departed ones unless --restore_trashed
+// is set. Notes of linked notebooks are not restored. Notes that were restored
+// before are skipped.
+func restore(guids []string) error {
+	if *layoutFlag != "notebooks" {
+		return errors.New("only backups with --layout=notebooks can be restored")
+	}
+	lock, err := lockDestDir(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	repo, err := repository.Load(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	r, err := newRestorer(context.Background(), ns, client.authToken, repo)
+	if err != nil {
+		return err
+	}
+
+	if len(guids) == 0 {
+		guids = restoreCandidates(repo, *restoreTrashedFlag)
+	}
+	layout := notebookLayout{*destDirFlag, repo}
+	failed := 0
+	for _, guid := range guids {
+		e, ok := repo.Get(guid)
+		if !ok {
+			return fmt.Errorf("note %s is not in the backup", guid)
+		}
+		nb, _ := repo.Notebook(e.NotebookGUID)
+		if *restoreNotebookFlag != "" && (nb == nil || nb.Name != *restoreNotebookFlag) {
+			continue
+		}
+		if created, ok := r.restored[guid]; ok {
+			log.Printf("Note %q was already restored as %s, skipping", e.Title, created)
+			continue
+		}
+		note, err := readBackedUpNote(layout, e)
+		if err == nil {
+			err = r.restoreNote(guid, note, nb)
+		}
+		if err != nil {
+			log.Printf("Can't restore note %q: %s", e.Title, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d notes could not be restored", failed)
+	}
+	return nil
+}
+
+// restoreCandidates returns the GUIDs of the notes that are restored if no
+// GUIDs are given: the notes of the user's own account and, if withTrashed is
+// set, also the trashed ones and the ones that are no longer in the account.
+func restoreCandidates(repo *repository.Repo, withTrashed bool) []string {
+	var res []string
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		if e.Source != "" {
+			continue
+		}
+		if (e.Trashed || e.Departed != nil) && !withTrashed {
+			continue
+		}
+		res = append(res, guid)
+	}
+	return res
+}
+
+func loadRestored(filename string) (map[string]string, error) {
+	restored := make(map[string]string)
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return restored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return restored, json.Unmarshal(b, &restored)
+}
+
+func newRestorer(ctx context.Context, ns edam.NoteStore, authToken string, repo *repository.Repo) (*restorer, error) {
+	restoredFile := filepath.Join(*destDirFlag, restoredFileName)
+	restored, err := loadRestored(restoredFile)
+	if err != nil {
+		return nil, err
+	}
+	r := &restorer{
+		ctx:          ctx,
+		ns:           ns,
+		authToken:    authToken,
+		repo:         repo,
+		dryRun:       *dryRunFlag,
+		notebooks:    make(map[string]edam.GUID),
+		tags:         make(map[string]edam.GUID),
+		restored:     restored,
+		restoredFile: restoredFile,
+	}
+	notebooks, err := ns.ListNotebooks(ctx, authToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, nb := range notebooks {
+		r.notebooks[strings.ToLower(nb.GetName())] = nb.GetGUID()
+	}
+	tags, err := ns.ListTags(ctx, authToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		r.tags[strings.ToLower(t.GetName())] = t.GetGUID()
+	}
+	return r, nil
+}
+
+// restoreNote creates note in the account and records that it was restored
+// from the note with GUID backupGUID in the backup.
+func (r *restorer) restoreNote(backupGUID string, note *edam.Note, nb *repository.Notebook) error {
+	upload := noteForUpload(note)
+	if nb != nil {
+		guid, err := r.notebookGUID(nb)
+		if err != nil {
+			return err
+		}
+		upload.NotebookGuid = (*string)(&guid)
+	}
+	for _, name := range note.TagNames {
+		guid, err := r.tagGUID(name, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+		upload.TagGuids = append(upload.TagGuids, guid)
+	}
+
+	if r.dryRun {
+		log.Printf("Would create note %q with %d attachments", upload.GetTitle(), len(upload.Resources))
+		return nil
+	}
+	created, err := r.ns.CreateNote(r.ctx, r.authToken, upload)
+	if err != nil {
+		return err
+	}
+	log.Printf("Created note %q (%s)", created.GetTitle(), created.GetGUID())
+	r.restored[backupGUID] = string(created.GetGUID())
+	b, err := json.MarshalIndent(r.restored, "", " ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(r.restoredFile, b, 0644)
+}
+
+// notebookGUID returns the GUID of the notebook in the account with the same
+// name as nb, creating it if necessary.
+func (r *restorer) notebookGUID(nb *repository.Notebook) (edam.GUID, error) {
+	if guid, ok := r.notebooks[strings.ToLower(nb.Name)]; ok {
+		return guid, nil
+	}
+	var guid edam.GUID
+	if r.dryRun {
+		log.Printf("Would create notebook %q", nb.Name)
+	} else {
+		notebook := &edam.Notebook{Name: &nb.Name}
+		if nb.Stack != "" {
+			notebook.Stack = &nb.Stack
+		}
+		created, err := r.ns.CreateNotebook(r.ctx, r.authToken, notebook)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Created notebook %q", nb.Name)
+		guid = created.GetGUID()
+	}
+	r.notebooks[strings.ToLower(nb.Name)] = guid
+	return guid, nil
+}
+
+// tagGUID returns the GUID of the tag in the account with the given name. If
+// it doesn't exist, it is created below the same parent as in the backup.
+func (r *restorer) tagGUID(name string, seen map[string]bool) (edam.GUID, error) {
+	if guid, ok := r.tags[strings.ToLower(name)]; ok {
+		return guid, nil
+	}
+	seen[name] = true
+
+	tag := &edam.Tag{Name: &name}
+	for _, guid := range r.repo.TagGUIDs() {
+		t, _ := r.repo.Tag(guid)
+		if t.Name != name || t.ParentGUID == "" {
+			continue
+		}
+		if parent, ok := r.repo.Tag(t.ParentGUID); ok && !seen[parent.Name] {
+			parentGUID, err := r.tagGUID(parent.Name, seen)
+			if err != nil {
+				return "", err
+			}
+			if parentGUID != "" {
+				tag.ParentGuid = &parentGUID
+			}
+		}
+		break
+	}
+
+	var guid edam.GUID
+	if r.dryRun {
+		log.Printf("Would create tag %q", name)
+	} else {
+		created, err := r.ns.CreateTag(r.ctx, r.authToken, tag)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Created tag %q", name)
+		guid = created.GetGUID()
+	}
+	r.tags[strings.ToLower(name)] = guid
+	return guid, nil
+}
+
+// noteForUpload returns a copy of a backed up note that only has the fields
+// that can be set when creating a note. Everything that is specific to the
+// account the note was backed up from is dropped.
+func noteForUpload(note *edam.Note) *edam.Note {
+	res := &edam.Note{
+		Title:   note.Title,
+		Content: note.Content,
+		Created: note.Created,
+		Updated: note.Updated,
+	}
+	if note.Attributes != nil {
+		attrs := *note.Attributes
+		attrs.CreatorId = nil
+		attrs.LastEditorId = nil
+		attrs.LastEditedBy = nil
+		attrs.SharedWithBusiness = nil
+		attrs.ShareDate = nil
+		attrs.ConflictSourceNoteGuid = nil
+		res.Attributes = &attrs
+	}
+	for _, r := range note.Resources {
+		res.Resources = append(res.Resources, &edam.Resource{
+			Data:       r.Data,
+			Mime:       r.Mime,
+			Width:      r.Width,
+			Height:     r.Height,
+			Duration:   r.Duration,
+			Attributes: r.Attributes,
+		})
+	}
+	return res
+}
+
+// readBackedUpNote reads a note and its attachments from the backup. If the
+// note has no note.json, its ENML is rebuilt from the HTML file.
+func readBackedUpNote(layout notebookLayout, e *repository.Entry) (*edam.Note, error) {
+	dir := layout.noteDir(e)
+	m, err := readNoteMetadata(filepath.Join(dir, noteMetadataFileName))
+	if os.IsNotExist(err) {
+		return readHtmlNote(dir, e)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make(map[string][]byte)
+	for _, r := range m.Resources {
+		body, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(r.Path)))
+		if err != nil {
+			return nil, err
+		}
+		if hash := md5.Sum(body); hex.EncodeToString(hash[:]) != r.MD5 {
+			return nil, fmt.Errorf("attachment %s doesn't match its hash", r.Path)
+		}
+		bodies[r.MD5] = body
+	}
+	note := m.Note
+	for _, r := range note.Resources {
+		hash := hex.EncodeToString(r.GetData().GetBodyHash())
+		body, ok := bodies[hash]
+		if !ok {
+			return nil, fmt.Errorf("attachment with hash %s is missing", hash)
+		}
+		r.Data = resourceData(body)
+	}
+	return note, nil
+}
+
+// readHtmlNote reads a note that was backed up as HTML only.
+func readHtmlNote(dir string, e *repository.Entry) (*edam.Note, error) {
+	note := &edam.Note{Title: &e.Title, TagNames: e.Tags}
+
+	attachments := make(map[string]*edam.Resource)
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "files"))
+	for _, fi := range files {
+		body, err := ioutil.ReadFile(filepath.Join(dir, "files", fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(fi.Name()))
+		if i := strings.Index(mimeType, ";"); i >= 0 {
+			mimeType = mimeType[:i]
+		}
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		fileName := fi.Name()
+		r := &edam.Resource{
+			Data:       resourceData(body),
+			Mime:       &mimeType,
+			Attributes: &edam.ResourceAttributes{FileName: &fileName},
+		}
+		attachments["files/"+fi.Name()] = r
+		note.Resources = append(note.Resources, r)
+	}
+
+	f, err := os.Open(noteFileName(dir, e.Title, ".html"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := htmlToEnml(f, attachments)
+	if err != nil {
+		return nil, err
+	}
+	note.Content = &content
+	return note, nil
+}
+
+func resourceData(body []byte) *edam.Data {
+	hash := md5.Sum(body)
+	size := int32(len(body))
+	return &edam.Data{Body: body, BodyHash: hash[:], Size: &size}
+}
+
+// htmlToEnml turns HTML written by convertToHtml back into ENML. Links and
+// images pointing to one of the attachments become en-media elements again.
+func htmlToEnml(r io.Reader, attachments map[string]*edam.Resource) (string, error) {
+	var b strings.Builder
+	b.WriteString(enmlHeader)
+
+	media := func(tok html.Token, attr string) (string, bool) {
+		target, _ := findAttribute(tok, attr)
+		res, ok := attachments[target]
+		if !ok {
+			return "", false
+		}
+		s := fmt.Sprintf(`<en-media type="%s" hash="%x"`, html.EscapeString(res.GetMime()), res.Data.BodyHash)
+		for _, a := range []string{"width", "height"} {
+			if v, ok := findAttribute(tok, a); ok {
+				s += fmt.Sprintf(` %s="%s"`, a, html.EscapeString(v))
+			}
+		}
+		return s + "/>", true
+	}
+
+	z := html.NewTokenizer(r)
+	inBody := false
+	// skipLink is set while inside of a link that was replaced by en-media.
+	skipLink := false
+	for {
+		if z.Next() == html.ErrorToken {
+			if z.Err() == io.EOF {
+				break
+			}
+			return "", z.Err()
+		}
+		tok := z.Token()
+		switch tok.Type {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if tok.Data == "body" {
+				b.WriteString("<en-note>")
+				inBody = true
+				continue
+			}
+			if !inBody {
+				continue
+			}
+			switch tok.Data {
+			case "img":
+				if s, ok := media(tok, "src"); ok {
+					b.WriteString(s)
+					continue
+				}
+			case "a":
+				if s, ok := media(tok, "href"); ok {
+					b.WriteString(s)
+					skipLink = tok.Type == html.StartTagToken
+					continue
+				}
+			}
+			if isVoidElement(tok.Data) {
+				tok.Type = html.SelfClosingTagToken
+			}
+			b.WriteString(tok.String())
+		case html.EndTagToken:
+			switch {
+			case tok.Data == "body":
+				b.WriteString("</en-note>")
+				inBody = false
+			case tok.Data == "a" && skipLink:
+				skipLink = false
+			case inBody && !isVoidElement(tok.Data):
+				b.WriteString(tok.String())
+			}
+		case html.TextToken:
+			if inBody && !skipLink {
+				b.WriteString(tok.String())
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func isVoidElement(name string) bool {
+	switch name {
+	case "br", "hr", "img", "area", "col":
+		return true
+	}
+	return false
+}
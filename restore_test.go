@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestHtmlToEnml(t *testing.T) {
+	title := "Hello"
+	content := enmlHeader + `<en-note><div>Hi &amp; <b>there</b><br/><en-media type="image/png" hash="cafe" width="10"/></div><div><en-media type="application/pdf" hash="f00d"/></div></en-note>`
+	png, pdf := "image/png", "application/pdf"
+	pngName, pdfName := "a.png", "b.pdf"
+	note := noteWithResources{
+		note: &edam.Note{Title: &title, Content: &content},
+		resources: map[string]*edam.Resource{
+			"cafe": {Mime: &png, Attributes: &edam.ResourceAttributes{FileName: &pngName}},
+			"f00d": {Mime: &pdf, Attributes: &edam.ResourceAttributes{FileName: &pdfName}},
+		},
+	}
+	b := bytes.Buffer{}
+	note.convertToHtml(&b)
+
+	hash := func(s string) []byte {
+		h, _ := hex.DecodeString(s)
+		return h
+	}
+	attachments := map[string]*edam.Resource{
+		"files/a.png": {Mime: &png, Data: &edam.Data{BodyHash: hash("cafe")}},
+		"files/b.pdf": {Mime: &pdf, Data: &edam.Data{BodyHash: hash("f00d")}},
+	}
+	enml, err := htmlToEnml(&b, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := enmlHeader + `<en-note><div>Hi &amp; <b>there</b><br/><en-media type="image/png" hash="cafe" width="10"/></div><div><en-media type="application/pdf" hash="f00d"/></div></en-note>`
+	if enml != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, enml)
+	}
+}
+
+func TestRestoreCandidates(t *testing.T) {
+	repo := repository.New("/backup")
+	repo.Add(&repository.Entry{GUID: "a", Title: "Active"})
+	repo.Add(&repository.Entry{GUID: "b", Title: "Business", Source: repository.SourceBusiness})
+	repo.Add(&repository.Entry{GUID: "c", Title: "Trashed", Trashed: true})
+	repo.Add(&repository.Entry{GUID: "d", Title: "Departed", Departed: &repository.Departure{GUID: "d", Reason: repository.ReasonMissing}})
+
+	if got, want := restoreCandidates(repo, false), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreCandidates(false) = %v, want %v", got, want)
+	}
+	if got, want := restoreCandidates(repo, true), []string{"a", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreCandidates(true) = %v, want %v", got, want)
+	}
+}
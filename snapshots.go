@@ -0,0 +1,413 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/repository"
+)
+
+const (
+	// snapshotsDirName is the directory below the backup that holds the
+	// snapshot manifests, and the content of all snapshots in objects/.
+	snapshotsDirName = "_snapshots"
+	objectsDirName   = "objects"
+
+	snapshotTimeFormat = "20060102T150405Z"
+)
+
+var (
+	snapshotsFlag = flag.Bool("snapshots", true, "Take a snapshot of the backup after every sync, so that earlier states can be recovered. Unchanged files are shared between snapshots; use 'prune' to drop old ones or --snapshots=false to turn them off")
+	retentionFlag = flag.String("retention", "7 daily, 4 weekly, 12 monthly", "Snapshots kept by 'prune', e.g. \"7 daily, 4 weekly, 12 monthly, 5 yearly\"")
+
+	retentionRegexp = regexp.MustCompile(`^(\d+)\s*(daily|weekly|monthly|yearly)$`)
+)
+
+// snapshot is the manifest of the backup at one point in time. The content
+// of the files is stored in objects/ by its MD5 hash, so files that didn't
+// change are shared between snapshots.
+type snapshot struct {
+	ID    string                  `json:"-"`
+	Time  time.Time               `json:"time"`
+	Notes map[string]snapshotNote `json:"notes"`
+}
+
+type snapshotNote struct {
+	USN      int64  `json:"usn"`
+	Title    string `json:"title"`
+	Notebook string `json:"notebook,omitempty"`
+	// Dir is the note's directory, relative to the backup.
+	Dir string `json:"dir"`
+	// Files maps the note's files, relative to Dir, to their hashes.
+	Files map[string]string `json:"files"`
+}
+
+func snapshotsDir(destDir string) string {
+	return filepath.Join(destDir, snapshotsDirName)
+}
+
+func snapshotObjects(destDir string) blobStore {
+	return blobStore(filepath.Join(snapshotsDir(destDir), objectsDirName))
+}
+
+// addFile adds a file to the store and returns its hash. The file is hard
+// linked into the store if possible; this is safe because notes are never
+// modified in place.
+func (s blobStore) addFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum(b)
+	hash := hex.EncodeToString(sum[:])
+	if _, err := os.Stat(s.path(hash)); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(hash)), 0755); err != nil {
+		return "", err
+	}
+	if fi, err := os.Lstat(filename); err == nil && fi.Mode().IsRegular() && os.Link(filename, s.path(hash)) == nil {
+		return hash, nil
+	}
+	return hash, fileutil.WriteFile(s.path(hash), b, 0444)
+}
+
+// takeSnapshot records the current state of the backup in destDir,
+// including its linked notebooks. All files are hashed again, as files can
+// change without a new USN, e.g. when the formats or the layout change.
+func takeSnapshot(destDir string) error {
+	if *layoutFlag != "notebooks" {
+		log.Printf("Snapshots are only supported with --layout=notebooks")
+		return nil
+	}
+	snapshots, err := listSnapshots(destDir)
+	if err != nil {
+		return err
+	}
+	prev := &snapshot{}
+	if len(snapshots) > 0 {
+		if prev, err = loadSnapshot(destDir, snapshots[len(snapshots)-1].ID); err != nil {
+			return err
+		}
+	}
+
+	dirs := []string{destDir}
+	linked, _ := filepath.Glob(filepath.Join(destDir, linkedDirName, "*"))
+	dirs = append(dirs, linked...)
+
+	objects := snapshotObjects(destDir)
+	s := &snapshot{Time: time.Now().UTC(), Notes: make(map[string]snapshotNote)}
+	changed := false
+	for _, dir := range dirs {
+		repo, err := repository.Load(dir)
+		if err != nil {
+			return err
+		}
+		layout := notebookLayout{dir, repo}
+		for _, guid := range repo.GUIDs() {
+			e, _ := repo.Get(guid)
+			noteDir, _ := filepath.Rel(destDir, layout.noteDir(e))
+			n := snapshotNote{USN: e.UpdateSequenceNum, Title: e.Title, Notebook: e.NotebookGUID, Dir: filepath.ToSlash(noteDir)}
+			if n.Files, err = snapshotFiles(objects, layout.noteDir(e)); err != nil {
+				return err
+			}
+			if p, ok := prev.Notes[guid]; !ok || !reflect.DeepEqual(p, n) {
+				changed = true
+			}
+			s.Notes[guid] = n
+		}
+	}
+	if !changed && len(s.Notes) == len(prev.Notes) {
+		log.Printf("Backup did not change since snapshot %s", prev.ID)
+		return nil
+	}
+	return s.save(destDir)
+}
+
+// snapshotFiles adds the files in dir to the store.
+func snapshotFiles(objects blobStore, dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			// Note was never written, e.g. because it failed to download
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		hash, err := objects.addFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = hash
+		return nil
+	})
+	return files, err
+}
+
+func (s *snapshot) save(destDir string) error {
+	t := s.Time
+	for {
+		s.ID = t.Format(snapshotTimeFormat)
+		if _, err := os.Stat(snapshotFile(destDir, s.ID)); os.IsNotExist(err) {
+			break
+		}
+		t = t.Add(time.Second)
+	}
+	b, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		return err
+	}
+	log.Printf("Writing snapshot %s", s.ID)
+	return fileutil.WriteFile(snapshotFile(destDir, s.ID), b, 0644)
+}
+
+func snapshotFile(destDir, id string) string {
+	return filepath.Join(snapshotsDir(destDir), id+".json")
+}
+
+func loadSnapshot(destDir, id string) (*snapshot, error) {
+	b, err := ioutil.ReadFile(snapshotFile(destDir, id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("snapshot %s does not exist", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{ID: id}
+	return s, json.Unmarshal(b, s)
+}
+
+// listSnapshots returns the IDs and times of all snapshots, oldest first.
+func listSnapshots(destDir string) ([]snapshot, error) {
+	files, err := filepath.Glob(filepath.Join(snapshotsDir(destDir), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	res := []snapshot{}
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), ".json")
+		t, err := time.Parse(snapshotTimeFormat, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, snapshot{ID: id, Time: t})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// showSnapshots prints all snapshots of the backup.
+func showSnapshots() error {
+	snapshots, err := listSnapshots(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		full, err := loadSnapshot(*destDirFlag, s.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d notes\n", s.ID, len(full.Notes))
+	}
+	return nil
+}
+
+// showSnapshotNote writes a note as it was in the given snapshot to
+// --export_dir.
+func showSnapshotNote(id, guid string) error {
+	s, err := loadSnapshot(*destDirFlag, id)
+	if err != nil {
+		return err
+	}
+	n, ok := s.Notes[guid]
+	if !ok {
+		return fmt.Errorf("note %s is not in snapshot %s", guid, id)
+	}
+	objects := snapshotObjects(*destDirFlag)
+	dir := filepath.Join(*exportDirFlag, id, filepath.Base(filepath.FromSlash(n.Dir)))
+	for name, hash := range n.Files {
+		b, err := ioutil.ReadFile(objects.path(hash))
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Note %q as of %s written to %s\n", n.Title, s.Time.Format(time.RFC3339), dir)
+	return nil
+}
+
+// retentionPolicy is the number of snapshots to keep per period.
+type retentionPolicy struct {
+	daily, weekly, monthly, yearly int
+}
+
+func parseRetention(s string) (retentionPolicy, error) {
+	p := retentionPolicy{}
+	for _, part := range strings.Split(s, ",") {
+		m := retentionRegexp.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return p, fmt.Errorf("%q is not a valid retention rule", strings.TrimSpace(part))
+		}
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "daily":
+			p.daily = n
+		case "weekly":
+			p.weekly = n
+		case "monthly":
+			p.monthly = n
+		case "yearly":
+			p.yearly = n
+		}
+	}
+	return p, nil
+}
+
+// keep returns the snapshots to keep: for every period, the newest snapshot
+// of each of the most recent periods that have one. The newest snapshot is
+// always kept.
+func (p retentionPolicy) keep(snapshots []snapshot) map[string]bool {
+	res := make(map[string]bool)
+	if len(snapshots) == 0 {
+		return res
+	}
+	newestFirst := append([]snapshot{}, snapshots...)
+	sort.Slice(newestFirst, func(i, j int) bool { return newestFirst[i].ID > newestFirst[j].ID })
+	res[newestFirst[0].ID] = true
+
+	periods := []struct {
+		count  int
+		period func(t time.Time) string
+	}{
+		{p.daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", y, w)
+		}},
+		{p.monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{p.yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, period := range periods {
+		seen := make(map[string]bool)
+		for _, s := range newestFirst {
+			if len(seen) >= period.count {
+				break
+			}
+			key := period.period(s.Time)
+			if !seen[key] {
+				seen[key] = true
+				res[s.ID] = true
+			}
+		}
+	}
+	return res
+}
+
+// prune removes the snapshots that are not kept by --retention, and the
+// content that is no longer part of any snapshot.
+func prune() error {
+	policy, err := parseRetention(*retentionFlag)
+	if err != nil {
+		return err
+	}
+	lock, err := lockDestDir(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	snapshots, err := listSnapshots(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	keep := policy.keep(snapshots)
+	used := make(map[string]bool)
+	for _, s := range snapshots {
+		if !keep[s.ID] {
+			log.Printf("Removing snapshot %s", s.ID)
+			if err := os.Remove(snapshotFile(*destDirFlag, s.ID)); err != nil {
+				return err
+			}
+			continue
+		}
+		full, err := loadSnapshot(*destDirFlag, s.ID)
+		if err != nil {
+			return err
+		}
+		for _, n := range full.Notes {
+			for _, hash := range n.Files {
+				used[hash] = true
+			}
+		}
+	}
+
+	objects := snapshotObjects(*destDirFlag)
+	return filepath.Walk(string(objects), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == string(objects) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(string(objects), path)
+		if hash := strings.Replace(filepath.ToSlash(rel), "/", "", 1); !used[hash] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// snapshotCommand returns the command for "snapshots [<id> <guid>]".
+func snapshotCommand(args []string) (command, error) {
+	switch len(args) {
+	case 0:
+		return showSnapshots, nil
+	case 2:
+		return func() error {
+			return showSnapshotNote(args[0], args[1])
+		}, nil
+	}
+	return nil, errors.New("usage: snapshots [<snapshot> <note guid>]")
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asig/duplikator/repository"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	p, err := parseRetention("2 daily, 2 weekly, 1 monthly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshots []snapshot
+	for _, ts := range []string{
+		"20190601T120000Z", // older month
+		"20190715T120000Z", // last week of July
+		"20190722T120000Z", // week before
+		"20190729T080000Z", // day before, week of the newest
+		"20190729T200000Z", // newest of day before
+		"20190730T120000Z", // newest
+	} {
+		tm, _ := time.Parse(snapshotTimeFormat, ts)
+		snapshots = append(snapshots, snapshot{ID: ts, Time: tm})
+	}
+	keep := p.keep(snapshots)
+	expected := map[string]bool{
+		"20190730T120000Z": true, // daily, weekly, monthly
+		"20190729T200000Z": true, // daily
+		"20190722T120000Z": true, // weekly
+	}
+	if len(keep) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, keep)
+	}
+	for id := range expected {
+		if !keep[id] {
+			t.Errorf("Expected %s to be kept, got %v", id, keep)
+		}
+	}
+
+	if _, err := parseRetention("3 hourly"); err == nil {
+		t.Errorf("Expected error for invalid rule")
+	}
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	e := repo.GetOrAdd("g1")
+	e.Title = "Hello"
+	e.UpdateSequenceNum = 1
+	if err := repo.Save(); err != nil {
+		t.Fatal(err)
+	}
+	noteDir := notebookLayout{destDir, repo}.noteDir(e)
+	write := func(content string) {
+		if err := os.MkdirAll(noteDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filepath.Join(noteDir, "Hello.html"))
+		if err := ioutil.WriteFile(filepath.Join(noteDir, "Hello.html"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("v1")
+	if err := takeSnapshot(destDir); err != nil {
+		t.Fatal(err)
+	}
+	// Unchanged backups don't create a new snapshot.
+	if err := takeSnapshot(destDir); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, _ := listSnapshots(destDir)
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", len(snapshots))
+	}
+	first := snapshots[0].ID
+
+	write("v2")
+	e.UpdateSequenceNum = 2
+	if err := repo.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the second snapshot gets a different ID
+	if err := os.Rename(snapshotFile(destDir, first), snapshotFile(destDir, "20190101T000000Z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := takeSnapshot(destDir); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, _ = listSnapshots(destDir)
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+	old, err := loadSnapshot(destDir, "20190101T000000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(snapshotObjects(destDir).path(old.Notes["g1"].Files["Hello.html"]))
+	if err != nil || string(b) != "v1" {
+		t.Errorf("Expected old content v1, got %q (%v)", b, err)
+	}
+}
+
+func TestSnapshotRehashesFiles(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	e := repo.GetOrAdd("g1")
+	e.Title = "Hello"
+	e.UpdateSequenceNum = 1
+	if err := repo.Save(); err != nil {
+		t.Fatal(err)
+	}
+	noteDir := notebookLayout{destDir, repo}.noteDir(e)
+	if err := os.MkdirAll(noteDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(noteDir, "Hello.html")
+	if err := ioutil.WriteFile(filename, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := takeSnapshot(destDir); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, _ := listSnapshots(destDir)
+	if err := os.Rename(snapshotFile(destDir, snapshots[0].ID), snapshotFile(destDir, "20190101T000000Z")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The note is written again, e.g. in another format, but keeps its USN
+	os.Remove(filename)
+	if err := ioutil.WriteFile(filename, []byte("re-rendered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := takeSnapshot(destDir); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, _ = listSnapshots(destDir)
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+	latest, err := loadSnapshot(destDir, snapshots[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(snapshotObjects(destDir).path(latest.Notes["g1"].Files["Hello.html"]))
+	if err != nil || string(b) != "re-rendered" {
+		t.Errorf("Expected content re-rendered, got %q (%v)", b, err)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"html"
+	"io"
+)
+
+var embedAttachmentsFlag = flag.Bool("embed_attachments", false, "With --format=standalone, also embed attachments that are not images instead of linking them")
+
+// htmlStyle is the basic styling of notes written as HTML.
+const htmlStyle = `body { font-family: sans-serif; line-height: 1.4; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+img { max-width: 100%; height: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 4px; }
+`
+
+// writeHtmlHead writes everything up to the body of a note's HTML file.
+func (note noteWithResources) writeHtmlHead(w io.Writer) {
+	io.WriteString(w, `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>`+html.EscapeString(note.note.GetTitle())+`</title>
+<style>
+`+htmlStyle+`</style>
+</head>
+`)
+}
+
+// convertToStandaloneHtml writes a note as one HTML file that doesn't
+// depend on the files next to it: images, and with --embed_attachments
+// all other attachments, are embedded as data: URIs.
+func (note noteWithResources) convertToStandaloneHtml(w io.Writer) error {
+	return note.writeHtml(w, true)
+}
+
+// dataURI returns the attachment with the given hash as data: URI.
+func (note noteWithResources) dataURI(hash string) (string, bool) {
+	r, ok := note.resources[hash]
+	if !ok || r.Data == nil || r.Data.Body == nil {
+		return "", false
+	}
+	return "data:" + r.GetMime() + ";base64," + base64.StdEncoding.EncodeToString(r.Data.Body), true
+}
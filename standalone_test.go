@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+func TestStandaloneHtml(t *testing.T) {
+	resource := func(guid, mime, fileName, body string) *edam.Resource {
+		g := edam.GUID(guid)
+		return &edam.Resource{
+			GUID:       &g,
+			Mime:       &mime,
+			Data:       &edam.Data{Body: []byte(body)},
+			Attributes: &edam.ResourceAttributes{FileName: &fileName},
+		}
+	}
+	content := enmlHeader + `<en-note>` +
+		`<en-media type="image/png" hash="aaaa"/>` +
+		`<en-media type="application/pdf" hash="bbbb"/>` +
+		`</en-note>`
+	title := "Fish & Chips"
+	note := noteWithResources{
+		note: &edam.Note{Title: &title, Content: &content},
+		resources: map[string]*edam.Resource{
+			"aaaa": resource("img", "image/png", "image.png", "png"),
+			"bbbb": resource("doc", "application/pdf", "doc.pdf", "pdf"),
+		},
+	}
+
+	convert := func() string {
+		var b bytes.Buffer
+		if err := note.convertToStandaloneHtml(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	html := convert()
+	for _, s := range []string{
+		`<meta charset="utf-8">`,
+		`<title>Fish &amp; Chips</title>`,
+		`<img src="data:image/png;base64,cG5n"`,
+		`<a href="files/doc.pdf">doc.pdf</a>`,
+	} {
+		if !strings.Contains(html, s) {
+			t.Errorf("Expected %s in %s", s, html)
+		}
+	}
+
+	defer func(b bool) { *embedAttachmentsFlag = b }(*embedAttachmentsFlag)
+	*embedAttachmentsFlag = true
+	if html := convert(); !strings.Contains(html, `<a href="data:application/pdf;base64,cGRm" download="doc.pdf">doc.pdf</a>`) {
+		t.Errorf("Attachment not embedded: %s", html)
+	}
+
+	if _, err := parseFormats("html,standalone"); err == nil {
+		t.Errorf("Expected error for html and standalone")
+	}
+}
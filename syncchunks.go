@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+
+	"github.com/asig/duplikator/edam"
+)
+
+const maxSyncChunkEntries = 100
+
+// chunkFetcher returns the sync chunk that follows afterUSN.
+type chunkFetcher func(afterUSN int32) (*edam.SyncChunk, error)
+
+// syncChunks is the merged content of all sync chunks after a given USN.
+// Later chunks override earlier ones, so every GUID shows up at most once.
+type syncChunks struct {
+	updateCount int32
+
+	noteGUIDs []string
+	notes     map[string]*edam.Note
+	notebooks map[string]*edam.Notebook
+	tags      map[string]*edam.Tag
+
+	expungedNotes     []string
+	expungedNotebooks []string
+	expungedTags      []string
+}
+
+func newSyncChunks() *syncChunks {
+	return &syncChunks{
+		notes:     make(map[string]*edam.Note),
+		notebooks: make(map[string]*edam.Notebook),
+		tags:      make(map[string]*edam.Tag),
+	}
+}
+
+func collectSyncChunks(fetch chunkFetcher, afterUSN int32) (*syncChunks, error) {
+	res := newSyncChunks()
+	res.updateCount = afterUSN
+	for {
+		chunk, err := fetch(afterUSN)
+		if err != nil {
+			return res, err
+		}
+		res.add(chunk)
+		if chunk.ChunkHighUSN == nil || *chunk.ChunkHighUSN >= chunk.UpdateCount {
+			break
+		}
+		log.Printf("Fetched sync chunk up to USN %d of %d", *chunk.ChunkHighUSN, chunk.UpdateCount)
+		afterUSN = *chunk.ChunkHighUSN
+	}
+	return res, nil
+}
+
+func (c *syncChunks) add(chunk *edam.SyncChunk) {
+	c.updateCount = chunk.UpdateCount
+	for _, n := range chunk.Notes {
+		guid := string(*n.GUID)
+		if _, ok := c.notes[guid]; !ok {
+			c.noteGUIDs = append(c.noteGUIDs, guid)
+		}
+		c.notes[guid] = n
+	}
+	for _, nb := range chunk.Notebooks {
+		c.notebooks[string(*nb.GUID)] = nb
+	}
+	for _, t := range chunk.Tags {
+		c.tags[string(*t.GUID)] = t
+	}
+	for _, guid := range chunk.ExpungedNotes {
+		c.expungedNotes = append(c.expungedNotes, string(guid))
+	}
+	for _, guid := range chunk.ExpungedNotebooks {
+		c.expungedNotebooks = append(c.expungedNotebooks, string(guid))
+	}
+	for _, guid := range chunk.ExpungedTags {
+		c.expungedTags = append(c.expungedTags, string(guid))
+	}
+}
+
+func syncChunkFilter(includeExpunged bool) *edam.SyncChunkFilter {
+	return &edam.SyncChunkFilter{
+		IncludeNotes:     boolVal(true),
+		IncludeNotebooks: boolVal(true),
+		IncludeTags:      boolVal(true),
+		IncludeExpunged:  boolVal(includeExpunged),
+	}
+}
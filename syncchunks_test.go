@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+// fakeChunks serves sync chunks by the USN they follow and records the USNs
+// they were asked for.
+type fakeChunks struct {
+	chunks    map[int32]*edam.SyncChunk
+	requested []int32
+}
+
+func (f *fakeChunks) fetch(afterUSN int32) (*edam.SyncChunk, error) {
+	f.requested = append(f.requested, afterUSN)
+	return f.chunks[afterUSN], nil
+}
+
+func usn(n int32) *int32 {
+	return &n
+}
+
+func chunkNote(guid, title string) *edam.Note {
+	g := edam.GUID(guid)
+	return &edam.Note{GUID: &g, Title: &title}
+}
+
+func TestCollectSyncChunks(t *testing.T) {
+	nb := edam.GUID("nb1")
+	tag := edam.GUID("tag1")
+	f := &fakeChunks{chunks: map[int32]*edam.SyncChunk{
+		10: {
+			ChunkHighUSN: usn(20),
+			UpdateCount:  30,
+			Notes:        []*edam.Note{chunkNote("a", "A v1"), chunkNote("b", "B")},
+			Notebooks:    []*edam.Notebook{{GUID: &nb}},
+		},
+		20: {
+			ChunkHighUSN:  usn(30),
+			UpdateCount:   30,
+			Notes:         []*edam.Note{chunkNote("a", "A v2")},
+			Tags:          []*edam.Tag{{GUID: &tag}},
+			ExpungedNotes: []edam.GUID{"c"},
+			ExpungedTags:  []edam.GUID{"tag2"},
+		},
+	}}
+
+	chunks, err := collectSyncChunks(f.fetch, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.requested, []int32{10, 20}) {
+		t.Errorf("Expected chunks after USN 10 and 20, got %v", f.requested)
+	}
+	if chunks.updateCount != 30 {
+		t.Errorf("Expected update count 30, got %d", chunks.updateCount)
+	}
+	// The later chunk overrides the earlier one, but the order is kept
+	if !reflect.DeepEqual(chunks.noteGUIDs, []string{"a", "b"}) {
+		t.Errorf("Expected notes [a b], got %v", chunks.noteGUIDs)
+	}
+	if got := chunks.notes["a"].GetTitle(); got != "A v2" {
+		t.Errorf("Expected title of the later chunk, got %q", got)
+	}
+	if _, ok := chunks.notebooks["nb1"]; !ok {
+		t.Errorf("Notebook nb1 is missing")
+	}
+	if _, ok := chunks.tags["tag1"]; !ok {
+		t.Errorf("Tag tag1 is missing")
+	}
+	if !reflect.DeepEqual(chunks.expungedNotes, []string{"c"}) || !reflect.DeepEqual(chunks.expungedTags, []string{"tag2"}) || len(chunks.expungedNotebooks) != 0 {
+		t.Errorf("Unexpected expunged entries %v, %v, %v", chunks.expungedNotes, chunks.expungedNotebooks, chunks.expungedTags)
+	}
+}
+
+func TestCollectSyncChunksWithoutHighUSN(t *testing.T) {
+	// An empty chunk has no ChunkHighUSN, which ends the sync
+	f := &fakeChunks{chunks: map[int32]*edam.SyncChunk{
+		30: {UpdateCount: 30},
+	}}
+	chunks, err := collectSyncChunks(f.fetch, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.requested) != 1 {
+		t.Errorf("Expected one chunk to be fetched, got %v", f.requested)
+	}
+	if chunks.updateCount != 30 || len(chunks.noteGUIDs) != 0 {
+		t.Errorf("Unexpected chunks %+v", chunks)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/repository"
+)
+
+const (
+	tagsFileName = "tags.json"
+	// tagsDirName is the directory below the backup that holds the tag views.
+	tagsDirName = "_tags"
+)
+
+var tagViewsFlag = flag.Bool("tag_views", false, "Also write a directory per tag with links to all notes with that tag")
+
+// tagNode is a tag in tags.json, together with its child tags.
+type tagNode struct {
+	GUID       string     `json:"guid"`
+	Name       string     `json:"name"`
+	ParentGUID string     `json:"parentGuid,omitempty"`
+	Children   []*tagNode `json:"children,omitempty"`
+}
+
+// tagTree arranges the tags of the repository in a tree. Tags whose parent
+// is unknown are treated as top level tags.
+func tagTree(repo *repository.Repo) []*tagNode {
+	nodes := make(map[string]*tagNode)
+	for _, guid := range repo.TagGUIDs() {
+		t, _ := repo.Tag(guid)
+		nodes[guid] = &tagNode{GUID: t.GUID, Name: t.Name, ParentGUID: t.ParentGUID}
+	}
+	roots := []*tagNode{}
+	for _, guid := range repo.TagGUIDs() {
+		n := nodes[guid]
+		if parent, ok := nodes[n.ParentGUID]; ok && !isTagAncestor(nodes, n, parent) {
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	sortTagNodes(roots)
+	return roots
+}
+
+// isTagAncestor guards against cycles in broken tag hierarchies.
+func isTagAncestor(nodes map[string]*tagNode, n, of *tagNode) bool {
+	seen := make(map[string]bool)
+	for cur := of; cur != nil && !seen[cur.GUID]; cur = nodes[cur.ParentGUID] {
+		if cur == n {
+			return true
+		}
+		seen[cur.GUID] = true
+	}
+	return false
+}
+
+func sortTagNodes(nodes []*tagNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, n := range nodes {
+		sortTagNodes(n.Children)
+	}
+}
+
+func writeTagsJSON(destDir string, repo *repository.Repo) error {
+	b, err := json.MarshalIndent(tagTree(repo), "", " ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(filepath.Join(destDir, tagsFileName), b, 0644)
+}
+
+// noteTagGUIDs returns the GUIDs of the tags of a note. For entries written
+// before tag GUIDs were recorded, the tags are looked up by name.
+func noteTagGUIDs(repo *repository.Repo, e *repository.Entry) []string {
+	if len(e.TagGUIDs) > 0 || len(e.Tags) == 0 {
+		return e.TagGUIDs
+	}
+	byName := make(map[string]string)
+	for _, guid := range repo.TagGUIDs() {
+		t, _ := repo.Tag(guid)
+		byName[t.Name] = guid
+	}
+	var res []string
+	for _, name := range e.Tags {
+		if guid, ok := byName[name]; ok {
+			res = append(res, guid)
+		}
+	}
+	return res
+}
+
+// refreshTagNames updates the tag names of all entries that know their tags'
+// GUIDs, so that renamed tags show up under their new name. Entries with tags
+// that are not in the repository are left alone.
+func refreshTagNames(repo *repository.Repo) {
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		if len(e.TagGUIDs) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(e.TagGUIDs))
+		for _, tagGUID := range e.TagGUIDs {
+			if t, ok := repo.Tag(tagGUID); ok {
+				names = append(names, t.Name)
+			}
+		}
+		if len(names) == len(e.TagGUIDs) {
+			e.Tags = names
+		}
+	}
+}
+
+// writeTagViews creates a directory for every tag, nested like the tag
+// hierarchy, with a symlink to every note that has this tag. The views are
+// rebuilt from scratch, so renamed and expunged tags don't leave directories
+// behind.
+func writeTagViews(layout notebookLayout, repo *repository.Repo) error {
+	root := filepath.Join(layout.destDir, tagsDirName)
+	if err := os.RemoveAll(root); err != nil {
+		return err
+	}
+
+	// dirs maps tag GUIDs to their directories
+	dirs := make(map[string]string)
+	var collect func(nodes []*tagNode, parent string)
+	collect = func(nodes []*tagNode, parent string) {
+		for _, n := range nodes {
+			dirs[n.GUID] = filepath.Join(parent, makeFilename(n.Name))
+			collect(n.Children, dirs[n.GUID])
+		}
+	}
+	collect(tagTree(repo), root)
+
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		for _, tag := range noteTagGUIDs(repo, e) {
+			dir, ok := dirs[tag]
+			if !ok {
+				continue
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			noteDir := layout.noteDir(e)
+			target, err := filepath.Rel(dir, noteDir)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, filepath.Join(dir, filepath.Base(noteDir))); err != nil {
+				log.Printf("Can't create tag view for %q: %s", e.Title, err)
+			}
+		}
+	}
+	return nil
+}
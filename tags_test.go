@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/asig/duplikator/repository"
+)
+
+func tagViewsTest(t *testing.T) (notebookLayout, *repository.Entry) {
+	destDir, err := ioutil.TempDir("", "tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.New(destDir)
+	l := notebookLayout{destDir, repo}
+	e := repo.Add(&repository.Entry{GUID: "g1", Title: "Hello", Tags: []string{"Old"}, TagGUIDs: []string{"t1"}})
+	if err := os.MkdirAll(l.noteDir(e), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return l, e
+}
+
+// tagView returns the link to the note in the view of the tag with the given
+// path, or "" if there is none.
+func tagView(l notebookLayout, e *repository.Entry, path ...string) string {
+	link := filepath.Join(append(append([]string{l.destDir, tagsDirName}, path...), filepath.Base(l.noteDir(e)))...)
+	if _, err := os.Stat(link); err != nil {
+		return ""
+	}
+	return link
+}
+
+func TestTagViewsRenamedTag(t *testing.T) {
+	l, e := tagViewsTest(t)
+	defer os.RemoveAll(l.destDir)
+
+	l.repo.PutTag(&repository.Tag{GUID: "t1", Name: "Old"})
+	if err := writeTagViews(l, l.repo); err != nil {
+		t.Fatal(err)
+	}
+	if tagView(l, e, "Old") == "" {
+		t.Errorf("Expected note in the view of tag Old")
+	}
+
+	l.repo.PutTag(&repository.Tag{GUID: "t1", Name: "New"})
+	refreshTagNames(l.repo)
+	if err := writeTagViews(l, l.repo); err != nil {
+		t.Fatal(err)
+	}
+	if tagView(l, e, "New") == "" {
+		t.Errorf("Expected note in the view of tag New")
+	}
+	if _, err := os.Stat(filepath.Join(l.destDir, tagsDirName, "Old")); !os.IsNotExist(err) {
+		t.Errorf("Expected view of the old tag name to be removed")
+	}
+	if !reflect.DeepEqual(e.Tags, []string{"New"}) {
+		t.Errorf("Expected tags [New], got %v", e.Tags)
+	}
+}
+
+func TestTagViewsExpungedTag(t *testing.T) {
+	l, e := tagViewsTest(t)
+	defer os.RemoveAll(l.destDir)
+
+	l.repo.PutTag(&repository.Tag{GUID: "t1", Name: "Old"})
+	if err := writeTagViews(l, l.repo); err != nil {
+		t.Fatal(err)
+	}
+	l.repo.RemoveTag("t1")
+	refreshTagNames(l.repo)
+	if err := writeTagViews(l, l.repo); err != nil {
+		t.Fatal(err)
+	}
+	if tagView(l, e, "Old") != "" {
+		t.Errorf("Expected view of the expunged tag to be removed")
+	}
+}
+
+func TestTagViewsNestedTags(t *testing.T) {
+	l, e := tagViewsTest(t)
+	defer os.RemoveAll(l.destDir)
+
+	l.repo.PutTag(&repository.Tag{GUID: "p", Name: "Work"})
+	l.repo.PutTag(&repository.Tag{GUID: "t1", Name: "Project", ParentGUID: "p"})
+	// A tag with the same name elsewhere in the hierarchy gets its own view
+	l.repo.PutTag(&repository.Tag{GUID: "t2", Name: "Project"})
+	if err := writeTagViews(l, l.repo); err != nil {
+		t.Fatal(err)
+	}
+	if tagView(l, e, "Work", "Project") == "" {
+		t.Errorf("Expected note in the view of tag Work/Project")
+	}
+	if tagView(l, e, "Project") != "" {
+		t.Errorf("Expected note not to be in the view of the other tag Project")
+	}
+}
+
+func TestTagViewsWithoutTagGUIDs(t *testing.T) {
+	l, e := tagViewsTest(t)
+	defer os.RemoveAll(l.destDir)
+
+	// Entries written before tag GUIDs were recorded are matched by name
+	e.TagGUIDs = nil
+	l.repo.PutTag(&repository.Tag{GUID: "t1", Name: "Old"})
+	if err := writeTagViews(l, l.repo); err != nil {
+		t.Fatal(err)
+	}
+	if tagView(l, e, "Old") == "" {
+		t.Errorf("Expected note in the view of tag Old")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asig/duplikator/repository"
+)
+
+// task is an open to-do of a note.
+type task struct {
+	notebook string
+	note     string
+	reminder time.Time
+	text     string
+}
+
+// openTasks returns the text of all unchecked to-dos of a note. A to-do's
+// text runs up to the next to-do or the end of its line.
+func openTasks(root *enmlNode) []string {
+	var res []string
+	var current *string
+	var walk func(n *enmlNode)
+	walk = func(n *enmlNode) {
+		switch {
+		case n.name == "":
+			if current != nil {
+				*current += n.text
+			}
+			return
+		case n.name == "en-todo":
+			current = nil
+			if n.attrs["checked"] != "true" {
+				res = append(res, "")
+				current = &res[len(res)-1]
+			}
+			return
+		case n.name == "br" || isBlockElement(n.name) || n.name == "li":
+			current = nil
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+		if isBlockElement(n.name) || n.name == "li" {
+			current = nil
+		}
+	}
+	walk(root)
+	for i, t := range res {
+		res[i] = strings.Join(strings.Fields(t), " ")
+	}
+	return res
+}
+
+// listTasks prints the open to-dos of all notes in the backup, ordered by
+// the notes' reminder dates. Only notes with a note.json are considered, so
+// this doesn't work for the obsidian layout.
+func listTasks() error {
+	if *layoutFlag != "notebooks" {
+		return errors.New("'tasks' only works with --layout=notebooks")
+	}
+	destDir := *destDirFlag
+	dirs := []string{destDir}
+	linked, _ := filepath.Glob(filepath.Join(destDir, linkedDirName, "*"))
+	dirs = append(dirs, linked...)
+
+	var tasks []task
+	skipped := 0
+	for _, dir := range dirs {
+		repo, err := repository.Load(dir)
+		if err != nil {
+			return err
+		}
+		layout := notebookLayout{dir, repo}
+		for _, guid := range repo.GUIDs() {
+			e, _ := repo.Get(guid)
+			if e.Trashed || e.Departed != nil {
+				continue
+			}
+			m, err := readNoteMetadata(filepath.Join(layout.noteDir(e), noteMetadataFileName))
+			if os.IsNotExist(err) {
+				skipped++
+				continue
+			}
+			if err != nil || m.Note == nil {
+				log.Printf("Can't read note %q (%s): %s", e.Title, e.GUID, err)
+				continue
+			}
+			root, err := parseEnml(m.Note.GetContent())
+			if err != nil {
+				log.Printf("Can't parse note %q (%s): %s", e.Title, e.GUID, err)
+				continue
+			}
+			var reminder time.Time
+			if r := m.Note.GetAttributes().GetReminderTime(); r != 0 {
+				reminder = time.Unix(0, int64(r)*int64(time.Millisecond))
+			}
+			nb := ""
+			if n, ok := repo.Notebook(e.NotebookGUID); ok {
+				nb = n.Name
+				if n.Stack != "" {
+					nb = n.Stack + "/" + n.Name
+				}
+			}
+			for _, text := range openTasks(root) {
+				tasks = append(tasks, task{notebook: nb, note: e.Title, reminder: reminder, text: text})
+			}
+		}
+	}
+
+	if skipped > 0 {
+		log.Printf("%d notes have no %s and were skipped", skipped, noteMetadataFileName)
+	}
+
+	// Tasks with a reminder come first, the earliest one first.
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.reminder.IsZero() != b.reminder.IsZero() {
+			return !a.reminder.IsZero()
+		}
+		if !a.reminder.Equal(b.reminder) {
+			return a.reminder.Before(b.reminder)
+		}
+		if a.notebook != b.notebook {
+			return a.notebook < b.notebook
+		}
+		return a.note < b.note
+	})
+	for _, t := range tasks {
+		reminder := "-"
+		if !t.reminder.IsZero() {
+			reminder = t.reminder.Format("2006-01-02")
+		}
+		fmt.Printf("%s\t%s\t%s\t[ ] %s\n", reminder, t.notebook, t.note, t.text)
+	}
+	return nil
+}
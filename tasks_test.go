@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+)
+
+const todoContent = enmlHeader + `<en-note>
+<div><en-todo checked="true"/>Buy milk</div>
+<div><en-todo/>Call <b>Bob</b><br/>not part of the task</div>
+<ul><li><en-todo checked="false"/>Water plants</li></ul>
+</en-note>`
+
+func TestOpenTasks(t *testing.T) {
+	root, err := parseEnml(todoContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := openTasks(root)
+	expected := []string{"Call Bob", "Water plants"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestHtmlTodos(t *testing.T) {
+	content := todoContent
+	note := noteWithResources{note: &edam.Note{Content: &content}}
+	var b bytes.Buffer
+	if err := note.convertToHtml(&b); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	if !strings.Contains(html, `<input type="checkbox" disabled checked>Buy milk`) {
+		t.Errorf("Checked to-do not rendered: %s", html)
+	}
+	if strings.Count(html, `<input type="checkbox" disabled>`) != 2 || strings.Contains(html, "en-todo") {
+		t.Errorf("Unchecked to-dos not rendered: %s", html)
+	}
+}
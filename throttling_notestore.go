@@ -31,6 +31,9 @@ type throttlingNoteStore struct {
 
 func (t throttlingNoteStore) GetSyncState(ctx context.Context, authenticationToken string) (r *edam.SyncState, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetSyncState(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -41,6 +44,9 @@ func (t throttlingNoteStore) GetSyncState(ctx context.Context, authenticationTok
 
 func (t throttlingNoteStore) GetFilteredSyncChunk(ctx context.Context, authenticationToken string, afterUSN int32, maxEntries int32, filter *edam.SyncChunkFilter) (r *edam.SyncChunk, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetFilteredSyncChunk(ctx, authenticationToken, afterUSN, maxEntries, filter)
 		if maybeThrottle(err) {
 			continue
@@ -51,6 +57,9 @@ func (t throttlingNoteStore) GetFilteredSyncChunk(ctx context.Context, authentic
 
 func (t throttlingNoteStore) GetLinkedNotebookSyncState(ctx context.Context, authenticationToken string, linkedNotebook *edam.LinkedNotebook) (r *edam.SyncState, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetLinkedNotebookSyncState(ctx, authenticationToken, linkedNotebook)
 		if maybeThrottle(err) {
 			continue
@@ -61,18 +70,22 @@ func (t throttlingNoteStore) GetLinkedNotebookSyncState(ctx context.Context, aut
 
 func (t throttlingNoteStore) GetLinkedNotebookSyncChunk(ctx context.Context, authenticationToken string, linkedNotebook *edam.LinkedNotebook, afterUSN int32, maxEntries int32, fullSyncOnly bool) (r *edam.SyncChunk, err error) {
 	for {
-		for {
-			res, err := t.ns.GetLinkedNotebookSyncChunk(ctx, authenticationToken, linkedNotebook, afterUSN, maxEntries, fullSyncOnly)
-			if maybeThrottle(err) {
-				continue
-			}
-			return res, err
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
+		res, err := t.ns.GetLinkedNotebookSyncChunk(ctx, authenticationToken, linkedNotebook, afterUSN, maxEntries, fullSyncOnly)
+		if maybeThrottle(err) {
+			continue
 		}
+		return res, err
 	}
 }
 
 func (t throttlingNoteStore) ListNotebooks(ctx context.Context, authenticationToken string) (r []*edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ListNotebooks(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -83,6 +96,9 @@ func (t throttlingNoteStore) ListNotebooks(ctx context.Context, authenticationTo
 
 func (t throttlingNoteStore) ListAccessibleBusinessNotebooks(ctx context.Context, authenticationToken string) (r []*edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ListAccessibleBusinessNotebooks(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -93,6 +109,9 @@ func (t throttlingNoteStore) ListAccessibleBusinessNotebooks(ctx context.Context
 
 func (t throttlingNoteStore) GetNotebook(ctx context.Context, authenticationToken string, guid edam.GUID) (r *edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNotebook(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -103,6 +122,9 @@ func (t throttlingNoteStore) GetNotebook(ctx context.Context, authenticationToke
 
 func (t throttlingNoteStore) GetDefaultNotebook(ctx context.Context, authenticationToken string) (r *edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 
 		res, err := t.ns.GetDefaultNotebook(ctx, authenticationToken)
 		if maybeThrottle(err) {
@@ -114,6 +136,9 @@ func (t throttlingNoteStore) GetDefaultNotebook(ctx context.Context, authenticat
 
 func (t throttlingNoteStore) CreateNotebook(ctx context.Context, authenticationToken string, notebook *edam.Notebook) (r *edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CreateNotebook(ctx, authenticationToken, notebook)
 		if maybeThrottle(err) {
 			continue
@@ -125,6 +150,9 @@ func (t throttlingNoteStore) CreateNotebook(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) UpdateNotebook(ctx context.Context, authenticationToken string, notebook *edam.Notebook) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateNotebook(ctx, authenticationToken, notebook)
 		if maybeThrottle(err) {
 			continue
@@ -136,6 +164,9 @@ func (t throttlingNoteStore) UpdateNotebook(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) ExpungeNotebook(ctx context.Context, authenticationToken string, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 
 		res, err := t.ns.ExpungeNotebook(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
@@ -148,6 +179,9 @@ func (t throttlingNoteStore) ExpungeNotebook(ctx context.Context, authentication
 
 func (t throttlingNoteStore) ListTags(ctx context.Context, authenticationToken string) (r []*edam.Tag, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 
 		res, err := t.ns.ListTags(ctx, authenticationToken)
 		if maybeThrottle(err) {
@@ -160,6 +194,9 @@ func (t throttlingNoteStore) ListTags(ctx context.Context, authenticationToken s
 
 func (t throttlingNoteStore) ListTagsByNotebook(ctx context.Context, authenticationToken string, notebookGuid edam.GUID) (r []*edam.Tag, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
  		res, err := t.ns.ListTagsByNotebook(ctx, authenticationToken, notebookGuid)
 		if maybeThrottle(err) {
 			continue
@@ -171,6 +208,9 @@ func (t throttlingNoteStore) ListTagsByNotebook(ctx context.Context, authenticat
 
 func (t throttlingNoteStore) GetTag(ctx context.Context, authenticationToken string, guid edam.GUID) (r *edam.Tag, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
  		res, err := t.ns.GetTag(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -182,6 +222,9 @@ func (t throttlingNoteStore) GetTag(ctx context.Context, authenticationToken str
 
 func (t throttlingNoteStore) CreateTag(ctx context.Context, authenticationToken string, tag *edam.Tag) (r *edam.Tag, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CreateTag(ctx, authenticationToken, tag)
 		if maybeThrottle(err) {
 			continue
@@ -192,6 +235,9 @@ func (t throttlingNoteStore) CreateTag(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) UpdateTag(ctx context.Context, authenticationToken string, tag *edam.Tag) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateTag(ctx, authenticationToken, tag)
 		if maybeThrottle(err) {
 			continue
@@ -202,6 +248,9 @@ func (t throttlingNoteStore) UpdateTag(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) UntagAll(ctx context.Context, authenticationToken string, guid edam.GUID) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.ns.UntagAll(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -212,6 +261,9 @@ func (t throttlingNoteStore) UntagAll(ctx context.Context, authenticationToken s
 
 func (t throttlingNoteStore) ExpungeTag(ctx context.Context, authenticationToken string, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ExpungeTag(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -222,6 +274,9 @@ func (t throttlingNoteStore) ExpungeTag(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) ListSearches(ctx context.Context, authenticationToken string) (r []*edam.SavedSearch, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ListSearches(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -232,6 +287,9 @@ func (t throttlingNoteStore) ListSearches(ctx context.Context, authenticationTok
 
 func (t throttlingNoteStore) GetSearch(ctx context.Context, authenticationToken string, guid edam.GUID) (r *edam.SavedSearch, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetSearch(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -242,6 +300,9 @@ func (t throttlingNoteStore) GetSearch(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) CreateSearch(ctx context.Context, authenticationToken string, search *edam.SavedSearch) (r *edam.SavedSearch, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CreateSearch(ctx, authenticationToken, search)
 		if maybeThrottle(err) {
 			continue
@@ -252,6 +313,9 @@ func (t throttlingNoteStore) CreateSearch(ctx context.Context, authenticationTok
 
 func (t throttlingNoteStore) UpdateSearch(ctx context.Context, authenticationToken string, search *edam.SavedSearch) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateSearch(ctx, authenticationToken, search)
 		if maybeThrottle(err) {
 			continue
@@ -262,6 +326,9 @@ func (t throttlingNoteStore) UpdateSearch(ctx context.Context, authenticationTok
 
 func (t throttlingNoteStore) ExpungeSearch(ctx context.Context, authenticationToken string, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ExpungeSearch(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -272,6 +339,9 @@ func (t throttlingNoteStore) ExpungeSearch(ctx context.Context, authenticationTo
 
 func (t throttlingNoteStore) FindNoteOffset(ctx context.Context, authenticationToken string, filter *edam.NoteFilter, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.FindNoteOffset(ctx, authenticationToken, filter, guid)
 		if maybeThrottle(err) {
 			continue
@@ -282,6 +352,9 @@ func (t throttlingNoteStore) FindNoteOffset(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) FindNotesMetadata(ctx context.Context, authenticationToken string, filter *edam.NoteFilter, offset int32, maxNotes int32, resultSpec *edam.NotesMetadataResultSpec) (r *edam.NotesMetadataList, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.FindNotesMetadata(ctx, authenticationToken, filter, offset, maxNotes, resultSpec)
 		if maybeThrottle(err) {
 			continue
@@ -292,6 +365,9 @@ func (t throttlingNoteStore) FindNotesMetadata(ctx context.Context, authenticati
 
 func (t throttlingNoteStore) FindNoteCounts(ctx context.Context, authenticationToken string, filter *edam.NoteFilter, withTrash bool) (r *edam.NoteCollectionCounts, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.FindNoteCounts(ctx, authenticationToken, filter, withTrash)
 		if maybeThrottle(err) {
 			continue
@@ -302,6 +378,9 @@ func (t throttlingNoteStore) FindNoteCounts(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) GetNoteWithResultSpec(ctx context.Context, authenticationToken string, guid edam.GUID, resultSpec *edam.NoteResultSpec) (r *edam.Note, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteWithResultSpec(ctx, authenticationToken, guid, resultSpec)
 		if maybeThrottle(err) {
 			continue
@@ -312,6 +391,9 @@ func (t throttlingNoteStore) GetNoteWithResultSpec(ctx context.Context, authenti
 
 func (t throttlingNoteStore) GetNote(ctx context.Context, authenticationToken string, guid edam.GUID, withContent bool, withResourcesData bool, withResourcesRecognition bool, withResourcesAlternateData bool) (r *edam.Note, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNote(ctx, authenticationToken, guid, withContent, withResourcesData, withResourcesRecognition, withResourcesAlternateData)
 		if maybeThrottle(err) {
 			continue
@@ -322,6 +404,9 @@ func (t throttlingNoteStore) GetNote(ctx context.Context, authenticationToken st
 
 func (t throttlingNoteStore) GetNoteApplicationData(ctx context.Context, authenticationToken string, guid edam.GUID) (r *edam.LazyMap, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteApplicationData(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -332,6 +417,9 @@ func (t throttlingNoteStore) GetNoteApplicationData(ctx context.Context, authent
 
 func (t throttlingNoteStore) GetNoteApplicationDataEntry(ctx context.Context, authenticationToken string, guid edam.GUID, key string) (r string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteApplicationDataEntry(ctx, authenticationToken, guid, key)
 		if maybeThrottle(err) {
 			continue
@@ -342,6 +430,9 @@ func (t throttlingNoteStore) GetNoteApplicationDataEntry(ctx context.Context, au
 
 func (t throttlingNoteStore) SetNoteApplicationDataEntry(ctx context.Context, authenticationToken string, guid edam.GUID, key string, value string) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.SetNoteApplicationDataEntry(ctx, authenticationToken, guid, key, value)
 		if maybeThrottle(err) {
 			continue
@@ -352,6 +443,9 @@ func (t throttlingNoteStore) SetNoteApplicationDataEntry(ctx context.Context, au
 
 func (t throttlingNoteStore) UnsetNoteApplicationDataEntry(ctx context.Context, authenticationToken string, guid edam.GUID, key string) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UnsetNoteApplicationDataEntry(ctx, authenticationToken, guid, key)
 		if maybeThrottle(err) {
 			continue
@@ -362,6 +456,9 @@ func (t throttlingNoteStore) UnsetNoteApplicationDataEntry(ctx context.Context,
 
 func (t throttlingNoteStore) GetNoteContent(ctx context.Context, authenticationToken string, guid edam.GUID) (r string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteContent(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -372,6 +469,9 @@ func (t throttlingNoteStore) GetNoteContent(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) GetNoteSearchText(ctx context.Context, authenticationToken string, guid edam.GUID, noteOnly bool, tokenizeForIndexing bool) (r string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteSearchText(ctx, authenticationToken, guid, noteOnly, tokenizeForIndexing)
 		if maybeThrottle(err) {
 			continue
@@ -382,6 +482,9 @@ func (t throttlingNoteStore) GetNoteSearchText(ctx context.Context, authenticati
 
 func (t throttlingNoteStore) GetResourceSearchText(ctx context.Context, authenticationToken string, guid edam.GUID) (r string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceSearchText(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -392,6 +495,9 @@ func (t throttlingNoteStore) GetResourceSearchText(ctx context.Context, authenti
 
 func (t throttlingNoteStore) GetNoteTagNames(ctx context.Context, authenticationToken string, guid edam.GUID) (r []string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteTagNames(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -402,6 +508,9 @@ func (t throttlingNoteStore) GetNoteTagNames(ctx context.Context, authentication
 
 func (t throttlingNoteStore) CreateNote(ctx context.Context, authenticationToken string, note *edam.Note) (r *edam.Note, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CreateNote(ctx, authenticationToken, note)
 		if maybeThrottle(err) {
 			continue
@@ -412,6 +521,9 @@ func (t throttlingNoteStore) CreateNote(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) UpdateNote(ctx context.Context, authenticationToken string, note *edam.Note) (r *edam.Note, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateNote(ctx, authenticationToken, note)
 		if maybeThrottle(err) {
 			continue
@@ -422,6 +534,9 @@ func (t throttlingNoteStore) UpdateNote(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) DeleteNote(ctx context.Context, authenticationToken string, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.DeleteNote(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -432,6 +547,9 @@ func (t throttlingNoteStore) DeleteNote(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) ExpungeNote(ctx context.Context, authenticationToken string, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ExpungeNote(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -442,6 +560,9 @@ func (t throttlingNoteStore) ExpungeNote(ctx context.Context, authenticationToke
 
 func (t throttlingNoteStore) CopyNote(ctx context.Context, authenticationToken string, noteGuid edam.GUID, toNotebookGuid edam.GUID) (r *edam.Note, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CopyNote(ctx, authenticationToken, noteGuid, toNotebookGuid)
 		if maybeThrottle(err) {
 			continue
@@ -452,6 +573,9 @@ func (t throttlingNoteStore) CopyNote(ctx context.Context, authenticationToken s
 
 func (t throttlingNoteStore) ListNoteVersions(ctx context.Context, authenticationToken string, noteGuid edam.GUID) (r []*edam.NoteVersionId, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ListNoteVersions(ctx, authenticationToken, noteGuid)
 		if maybeThrottle(err) {
 			continue
@@ -462,6 +586,9 @@ func (t throttlingNoteStore) ListNoteVersions(ctx context.Context, authenticatio
 
 func (t throttlingNoteStore) GetNoteVersion(ctx context.Context, authenticationToken string, noteGuid edam.GUID, updateSequenceNum int32, withResourcesData bool, withResourcesRecognition bool, withResourcesAlternateData bool) (r *edam.Note, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNoteVersion(ctx, authenticationToken, noteGuid, updateSequenceNum, withResourcesData, withResourcesRecognition, withResourcesAlternateData)
 		if maybeThrottle(err) {
 			continue
@@ -472,6 +599,9 @@ func (t throttlingNoteStore) GetNoteVersion(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) GetResource(ctx context.Context, authenticationToken string, guid edam.GUID, withData bool, withRecognition bool, withAttributes bool, withAlternateData bool) (r *edam.Resource, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResource(ctx, authenticationToken, guid, withData, withRecognition, withAttributes, withAlternateData)
 		if maybeThrottle(err) {
 			continue
@@ -482,6 +612,9 @@ func (t throttlingNoteStore) GetResource(ctx context.Context, authenticationToke
 
 func (t throttlingNoteStore) GetResourceApplicationData(ctx context.Context, authenticationToken string, guid edam.GUID) (r *edam.LazyMap, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceApplicationData(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -492,6 +625,9 @@ func (t throttlingNoteStore) GetResourceApplicationData(ctx context.Context, aut
 
 func (t throttlingNoteStore) GetResourceApplicationDataEntry(ctx context.Context, authenticationToken string, guid edam.GUID, key string) (r string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceApplicationDataEntry(ctx, authenticationToken, guid, key)
 		if maybeThrottle(err) {
 			continue
@@ -502,6 +638,9 @@ func (t throttlingNoteStore) GetResourceApplicationDataEntry(ctx context.Context
 
 func (t throttlingNoteStore) SetResourceApplicationDataEntry(ctx context.Context, authenticationToken string, guid edam.GUID, key string, value string) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.SetResourceApplicationDataEntry(ctx, authenticationToken, guid, key, value)
 		if maybeThrottle(err) {
 			continue
@@ -512,6 +651,9 @@ func (t throttlingNoteStore) SetResourceApplicationDataEntry(ctx context.Context
 
 func (t throttlingNoteStore) UnsetResourceApplicationDataEntry(ctx context.Context, authenticationToken string, guid edam.GUID, key string) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UnsetResourceApplicationDataEntry(ctx, authenticationToken, guid, key)
 		if maybeThrottle(err) {
 			continue
@@ -522,6 +664,9 @@ func (t throttlingNoteStore) UnsetResourceApplicationDataEntry(ctx context.Conte
 
 func (t throttlingNoteStore) UpdateResource(ctx context.Context, authenticationToken string, resource *edam.Resource) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateResource(ctx, authenticationToken, resource)
 		if maybeThrottle(err) {
 			continue
@@ -532,6 +677,9 @@ func (t throttlingNoteStore) UpdateResource(ctx context.Context, authenticationT
 
 func (t throttlingNoteStore) GetResourceData(ctx context.Context, authenticationToken string, guid edam.GUID) (r []byte, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceData(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -542,6 +690,9 @@ func (t throttlingNoteStore) GetResourceData(ctx context.Context, authentication
 
 func (t throttlingNoteStore) GetResourceByHash(ctx context.Context, authenticationToken string, noteGuid edam.GUID, contentHash []byte, withData bool, withRecognition bool, withAlternateData bool) (r *edam.Resource, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceByHash(ctx, authenticationToken, noteGuid, contentHash, withData, withRecognition, withAlternateData)
 		if maybeThrottle(err) {
 			continue
@@ -552,6 +703,9 @@ func (t throttlingNoteStore) GetResourceByHash(ctx context.Context, authenticati
 
 func (t throttlingNoteStore) GetResourceRecognition(ctx context.Context, authenticationToken string, guid edam.GUID) (r []byte, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceRecognition(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -562,6 +716,9 @@ func (t throttlingNoteStore) GetResourceRecognition(ctx context.Context, authent
 
 func (t throttlingNoteStore) GetResourceAlternateData(ctx context.Context, authenticationToken string, guid edam.GUID) (r []byte, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceAlternateData(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -572,6 +729,9 @@ func (t throttlingNoteStore) GetResourceAlternateData(ctx context.Context, authe
 
 func (t throttlingNoteStore) GetResourceAttributes(ctx context.Context, authenticationToken string, guid edam.GUID) (r *edam.ResourceAttributes, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetResourceAttributes(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -582,6 +742,9 @@ func (t throttlingNoteStore) GetResourceAttributes(ctx context.Context, authenti
 
 func (t throttlingNoteStore) GetPublicNotebook(ctx context.Context, userId edam.UserID, publicUri string) (r *edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetPublicNotebook(ctx, userId, publicUri)
 		if maybeThrottle(err) {
 			continue
@@ -592,6 +755,9 @@ func (t throttlingNoteStore) GetPublicNotebook(ctx context.Context, userId edam.
 
 func (t throttlingNoteStore) ShareNotebook(ctx context.Context, authenticationToken string, sharedNotebook *edam.SharedNotebook, message string) (r *edam.SharedNotebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ShareNotebook(ctx, authenticationToken, sharedNotebook, message)
 		if maybeThrottle(err) {
 			continue
@@ -602,6 +768,9 @@ func (t throttlingNoteStore) ShareNotebook(ctx context.Context, authenticationTo
 
 func (t throttlingNoteStore) CreateOrUpdateNotebookShares(ctx context.Context, authenticationToken string, shareTemplate *edam.NotebookShareTemplate) (r *edam.CreateOrUpdateNotebookSharesResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CreateOrUpdateNotebookShares(ctx, authenticationToken, shareTemplate)
 		if maybeThrottle(err) {
 			continue
@@ -612,6 +781,9 @@ func (t throttlingNoteStore) CreateOrUpdateNotebookShares(ctx context.Context, a
 
 func (t throttlingNoteStore) UpdateSharedNotebook(ctx context.Context, authenticationToken string, sharedNotebook *edam.SharedNotebook) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateSharedNotebook(ctx, authenticationToken, sharedNotebook)
 		if maybeThrottle(err) {
 			continue
@@ -622,6 +794,9 @@ func (t throttlingNoteStore) UpdateSharedNotebook(ctx context.Context, authentic
 
 func (t throttlingNoteStore) SetNotebookRecipientSettings(ctx context.Context, authenticationToken string, notebookGuid string, recipientSettings *edam.NotebookRecipientSettings) (r *edam.Notebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.SetNotebookRecipientSettings(ctx, authenticationToken, notebookGuid, recipientSettings)
 		if maybeThrottle(err) {
 			continue
@@ -632,6 +807,9 @@ func (t throttlingNoteStore) SetNotebookRecipientSettings(ctx context.Context, a
 
 func (t throttlingNoteStore) ListSharedNotebooks(ctx context.Context, authenticationToken string) (r []*edam.SharedNotebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ListSharedNotebooks(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -642,6 +820,9 @@ func (t throttlingNoteStore) ListSharedNotebooks(ctx context.Context, authentica
 
 func (t throttlingNoteStore) CreateLinkedNotebook(ctx context.Context, authenticationToken string, linkedNotebook *edam.LinkedNotebook) (r *edam.LinkedNotebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.CreateLinkedNotebook(ctx, authenticationToken, linkedNotebook)
 		if maybeThrottle(err) {
 			continue
@@ -652,6 +833,9 @@ func (t throttlingNoteStore) CreateLinkedNotebook(ctx context.Context, authentic
 
 func (t throttlingNoteStore) UpdateLinkedNotebook(ctx context.Context, authenticationToken string, linkedNotebook *edam.LinkedNotebook) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateLinkedNotebook(ctx, authenticationToken, linkedNotebook)
 		if maybeThrottle(err) {
 			continue
@@ -662,6 +846,9 @@ func (t throttlingNoteStore) UpdateLinkedNotebook(ctx context.Context, authentic
 
 func (t throttlingNoteStore) ListLinkedNotebooks(ctx context.Context, authenticationToken string) (r []*edam.LinkedNotebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ListLinkedNotebooks(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -672,6 +859,9 @@ func (t throttlingNoteStore) ListLinkedNotebooks(ctx context.Context, authentica
 
 func (t throttlingNoteStore) ExpungeLinkedNotebook(ctx context.Context, authenticationToken string, guid edam.GUID) (r int32, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ExpungeLinkedNotebook(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -682,6 +872,9 @@ func (t throttlingNoteStore) ExpungeLinkedNotebook(ctx context.Context, authenti
 
 func (t throttlingNoteStore) AuthenticateToSharedNotebook(ctx context.Context, shareKeyOrGlobalId string, authenticationToken string) (r *edam.AuthenticationResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.AuthenticateToSharedNotebook(ctx, shareKeyOrGlobalId, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -692,6 +885,9 @@ func (t throttlingNoteStore) AuthenticateToSharedNotebook(ctx context.Context, s
 
 func (t throttlingNoteStore) GetSharedNotebookByAuth(ctx context.Context, authenticationToken string) (r *edam.SharedNotebook, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetSharedNotebookByAuth(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -702,6 +898,9 @@ func (t throttlingNoteStore) GetSharedNotebookByAuth(ctx context.Context, authen
 
 func (t throttlingNoteStore) EmailNote(ctx context.Context, authenticationToken string, parameters *edam.NoteEmailParameters) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.ns.EmailNote(ctx, authenticationToken, parameters)
 		if maybeThrottle(err) {
 			continue
@@ -712,6 +911,9 @@ func (t throttlingNoteStore) EmailNote(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) ShareNote(ctx context.Context, authenticationToken string, guid edam.GUID) (r string, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ShareNote(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -722,6 +924,9 @@ func (t throttlingNoteStore) ShareNote(ctx context.Context, authenticationToken
 
 func (t throttlingNoteStore) StopSharingNote(ctx context.Context, authenticationToken string, guid edam.GUID) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.ns.StopSharingNote(ctx, authenticationToken, guid)
 		if maybeThrottle(err) {
 			continue
@@ -732,6 +937,9 @@ func (t throttlingNoteStore) StopSharingNote(ctx context.Context, authentication
 
 func (t throttlingNoteStore) AuthenticateToSharedNote(ctx context.Context, guid string, noteKey string, authenticationToken string) (r *edam.AuthenticationResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.AuthenticateToSharedNote(ctx, guid, noteKey, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -742,6 +950,9 @@ func (t throttlingNoteStore) AuthenticateToSharedNote(ctx context.Context, guid
 
 func (t throttlingNoteStore) FindRelated(ctx context.Context, authenticationToken string, query *edam.RelatedQuery, resultSpec *edam.RelatedResultSpec) (r *edam.RelatedResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.FindRelated(ctx, authenticationToken, query, resultSpec)
 		if maybeThrottle(err) {
 			continue
@@ -752,6 +963,9 @@ func (t throttlingNoteStore) FindRelated(ctx context.Context, authenticationToke
 
 func (t throttlingNoteStore) UpdateNoteIfUsnMatches(ctx context.Context, authenticationToken string, note *edam.Note) (r *edam.UpdateNoteIfUsnMatchesResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.UpdateNoteIfUsnMatches(ctx, authenticationToken, note)
 		if maybeThrottle(err) {
 			continue
@@ -762,6 +976,9 @@ func (t throttlingNoteStore) UpdateNoteIfUsnMatches(ctx context.Context, authent
 
 func (t throttlingNoteStore) ManageNotebookShares(ctx context.Context, authenticationToken string, parameters *edam.ManageNotebookSharesParameters) (r *edam.ManageNotebookSharesResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.ManageNotebookShares(ctx, authenticationToken, parameters)
 		if maybeThrottle(err) {
 			continue
@@ -772,6 +989,9 @@ func (t throttlingNoteStore) ManageNotebookShares(ctx context.Context, authentic
 
 func (t throttlingNoteStore) GetNotebookShares(ctx context.Context, authenticationToken string, notebookGuid string) (r *edam.ShareRelationships, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.ns.GetNotebookShares(ctx, authenticationToken, notebookGuid)
 		if maybeThrottle(err) {
 			continue
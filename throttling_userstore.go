@@ -31,6 +31,9 @@ type throttlingUserStore struct {
 
 func (t throttlingUserStore) CheckVersion(ctx context.Context, clientName string, edamVersionMajor int16, edamVersionMinor int16) (r bool, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.CheckVersion(ctx, clientName, edamVersionMajor, edamVersionMinor)
 		if maybeThrottle(err) {
 			continue
@@ -41,6 +44,9 @@ func (t throttlingUserStore) CheckVersion(ctx context.Context, clientName string
 
 func (t throttlingUserStore) GetBootstrapInfo(ctx context.Context, locale string) (r *edam.BootstrapInfo, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.GetBootstrapInfo(ctx, locale)
 		if maybeThrottle(err) {
 			continue
@@ -51,6 +57,9 @@ func (t throttlingUserStore) GetBootstrapInfo(ctx context.Context, locale string
 
 func (t throttlingUserStore) AuthenticateLongSession(ctx context.Context, username string, password string, consumerKey string, consumerSecret string, deviceIdentifier string, deviceDescription string, supportsTwoFactor bool) (r *edam.AuthenticationResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.AuthenticateLongSession(ctx, username, password, consumerSecret, consumerSecret, deviceIdentifier, deviceDescription, supportsTwoFactor)
 		if maybeThrottle(err) {
 			continue
@@ -61,6 +70,9 @@ func (t throttlingUserStore) AuthenticateLongSession(ctx context.Context, userna
 
 func (t throttlingUserStore) CompleteTwoFactorAuthentication(ctx context.Context, authenticationToken string, oneTimeCode string, deviceIdentifier string, deviceDescription string) (r *edam.AuthenticationResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.CompleteTwoFactorAuthentication(ctx, authenticationToken, oneTimeCode, deviceIdentifier, deviceDescription)
 		if maybeThrottle(err) {
 			continue
@@ -71,6 +83,9 @@ func (t throttlingUserStore) CompleteTwoFactorAuthentication(ctx context.Context
 
 func (t throttlingUserStore) RevokeLongSession(ctx context.Context, authenticationToken string) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.us.RevokeLongSession(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -81,6 +96,9 @@ func (t throttlingUserStore) RevokeLongSession(ctx context.Context, authenticati
 
 func (t throttlingUserStore) AuthenticateToBusiness(ctx context.Context, authenticationToken string) (r *edam.AuthenticationResult_, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.AuthenticateToBusiness(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -91,6 +109,9 @@ func (t throttlingUserStore) AuthenticateToBusiness(ctx context.Context, authent
 
 func (t throttlingUserStore) GetUser(ctx context.Context, authenticationToken string) (r *edam.User, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.GetUser(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -101,6 +122,9 @@ func (t throttlingUserStore) GetUser(ctx context.Context, authenticationToken st
 
 func (t throttlingUserStore) GetPublicUserInfo(ctx context.Context, username string) (r *edam.PublicUserInfo, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.GetPublicUserInfo(ctx, username)
 		if maybeThrottle(err) {
 			continue
@@ -111,6 +135,9 @@ func (t throttlingUserStore) GetPublicUserInfo(ctx context.Context, username str
 
 func (t throttlingUserStore) GetUserUrls(ctx context.Context, authenticationToken string) (r *edam.UserUrls, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.GetUserUrls(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -121,6 +148,9 @@ func (t throttlingUserStore) GetUserUrls(ctx context.Context, authenticationToke
 
 func (t throttlingUserStore) InviteToBusiness(ctx context.Context, authenticationToken string, emailAddress string) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.us.InviteToBusiness(ctx, authenticationToken, emailAddress)
 		if maybeThrottle(err) {
 			continue;
@@ -131,6 +161,9 @@ func (t throttlingUserStore) InviteToBusiness(ctx context.Context, authenticatio
 
 func (t throttlingUserStore) RemoveFromBusiness(ctx context.Context, authenticationToken string, emailAddress string) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.us.RemoveFromBusiness(ctx, authenticationToken, emailAddress)
 		if maybeThrottle(err) {
 			continue
@@ -141,6 +174,9 @@ func (t throttlingUserStore) RemoveFromBusiness(ctx context.Context, authenticat
 
 func (t throttlingUserStore) UpdateBusinessUserIdentifier(ctx context.Context, authenticationToken string, oldEmailAddress string, newEmailAddress string) (err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		err = t.us.UpdateBusinessUserIdentifier(ctx, authenticationToken, oldEmailAddress, newEmailAddress)
 		if maybeThrottle(err) {
 			continue
@@ -151,6 +187,9 @@ func (t throttlingUserStore) UpdateBusinessUserIdentifier(ctx context.Context, a
 
 func (t throttlingUserStore) ListBusinessUsers(ctx context.Context, authenticationToken string) (r []*edam.UserProfile, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.ListBusinessUsers(ctx, authenticationToken)
 		if maybeThrottle(err) {
 			continue
@@ -161,6 +200,9 @@ func (t throttlingUserStore) ListBusinessUsers(ctx context.Context, authenticati
 
 func (t throttlingUserStore) ListBusinessInvitations(ctx context.Context, authenticationToken string, includeRequestedInvitations bool) (r []*edam.BusinessInvitation, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.ListBusinessInvitations(ctx, authenticationToken, includeRequestedInvitations)
 		if maybeThrottle(err) {
 			continue
@@ -171,6 +213,9 @@ func (t throttlingUserStore) ListBusinessInvitations(ctx context.Context, authen
 
 func (t throttlingUserStore) GetAccountLimits(ctx context.Context, serviceLevel edam.ServiceLevel) (r *edam.AccountLimits, err error) {
 	for {
+		if err = throttle.wait(ctx); err != nil {
+			return
+		}
 		res, err := t.us.GetAccountLimits(ctx, serviceLevel)
 		if maybeThrottle(err) {
 			continue
@@ -39,7 +39,25 @@ var (
 )
 
 func Init() (*Store, error) {
-	store := Store{filename: tokenStoreFileName()}
+	store, err := Open(tokenStoreFileName())
+	if err != nil {
+		return nil, err
+	}
+
+	if *accessTokenFlag != "" {
+		token, err := TokenFromString(*accessTokenFlag)
+		if err != nil {
+			return nil, err
+		}
+		store.Token = token
+	}
+	return store, nil
+}
+
+// Open reads the token store in the given file. Unlike Init, it ignores
+// --access_token, so it can be used for a second account.
+func Open(filename string) (*Store, error) {
+	store := Store{filename: filename}
 
 	b, err := ioutil.ReadFile(store.filename)
 	if err != nil && !os.IsNotExist(err) {
@@ -50,14 +68,6 @@ func Init() (*Store, error) {
 	if err == nil {
 		store.Token = token
 	}
-
-	if *accessTokenFlag != "" {
-		token, err = TokenFromString(*accessTokenFlag)
-		if err != nil {
-			return nil, err
-		}
-		store.Token = token
-	}
 	return &store, nil
 }
 
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/asig/duplikator/repository"
+)
+
+// expungedDirName is the directory below the destination dir that holds
+// notes that are gone from the server if --vanished=expunged is used.
+const expungedDirName = "_expunged"
+
+const expungedTimeFormat = "20060102T150405Z"
+
+var (
+	trashFlag    = flag.Bool("trash", false, "Also back up notes that are in the trash")
+	vanishedFlag = flag.String("vanished", "delete", "What to do with notes that are gone from the server: delete, expunged (move them to _expunged/) or keep. Linked notebooks that are gone are moved to _expunged/ unless this is keep")
+)
+
+func checkVanishedPolicy(policy string) error {
+	switch policy {
+	case "delete", "expunged", "keep":
+		return nil
+	}
+	return fmt.Errorf("%q is not a valid policy for vanished notes.", policy)
+}
+
+// removeNote handles a note that is gone from the server according to
+// --vanished, and records why it left.
+func (t *backupTarget) removeNote(repo *repository.Repo, layout noteLayout, guid, reason string) error {
+	e, ok := repo.Get(guid)
+	if !ok || e.Departed != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	d := repository.Departure{
+		GUID:   e.GUID,
+		Title:  e.Title,
+		Time:   now.UnixNano() / int64(time.Millisecond),
+		Reason: reason,
+	}
+	switch *vanishedFlag {
+	case "keep":
+		log.Printf("Keeping %q (%s), it was %s", e.Title, e.GUID, reason)
+		e.Departed = &d
+		repo.AddDeparture(d)
+		return nil
+	case "expunged":
+		dir := filepath.Join(t.destDir, expungedDirName, now.Format(expungedTimeFormat))
+		path, err := layout.archive(e, dir)
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			d.Path, _ = filepath.Rel(t.destDir, path)
+			d.Path = filepath.ToSlash(d.Path)
+		}
+		log.Printf("Moving %q (%s) to %s, it was %s", e.Title, e.GUID, d.Path, reason)
+	default:
+		log.Printf("Deleting %q (%s), it was %s", e.Title, e.GUID, reason)
+		layout.remove(e)
+	}
+	repo.AddDeparture(d)
+	repo.Remove(guid)
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+// vanishedNote sets up a backup with one note and returns the note's
+// directory.
+func vanishedNote(t *testing.T) (*backupTarget, *repository.Repo, notebookLayout, string) {
+	destDir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.New(destDir)
+	l := notebookLayout{destDir, repo}
+	e := repo.Add(&repository.Entry{GUID: "g1", Title: "Hello"})
+	recordPath(destDir, l, e)
+	dir := l.noteDir(e)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Hello.html"), []byte("Hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &backupTarget{destDir: destDir}, repo, l, dir
+}
+
+func TestRemoveNoteDelete(t *testing.T) {
+	defer func(p string) { *vanishedFlag = p }(*vanishedFlag)
+	*vanishedFlag = "delete"
+	target, repo, l, dir := vanishedNote(t)
+	defer os.RemoveAll(target.destDir)
+
+	if err := target.removeNote(repo, l, "g1", repository.ReasonExpunged); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be deleted", dir)
+	}
+	if _, ok := repo.Get("g1"); ok {
+		t.Errorf("Expected note to be removed from the repository")
+	}
+	if d := repo.Departures(); len(d) != 1 || d[0].Reason != repository.ReasonExpunged || d[0].Path != "" {
+		t.Errorf("Unexpected departures %v", d)
+	}
+}
+
+func TestRemoveNoteExpunged(t *testing.T) {
+	defer func(p string) { *vanishedFlag = p }(*vanishedFlag)
+	*vanishedFlag = "expunged"
+	target, repo, l, dir := vanishedNote(t)
+	defer os.RemoveAll(target.destDir)
+
+	if err := target.removeNote(repo, l, "g1", repository.ReasonMissing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be moved", dir)
+	}
+	if _, ok := repo.Get("g1"); ok {
+		t.Errorf("Expected note to be removed from the repository")
+	}
+	d := repo.Departures()
+	if len(d) != 1 || d[0].Reason != repository.ReasonMissing || !strings.HasPrefix(d[0].Path, expungedDirName+"/") {
+		t.Fatalf("Unexpected departures %v", d)
+	}
+	if _, err := os.Stat(filepath.Join(target.destDir, filepath.FromSlash(d[0].Path), "Hello.html")); err != nil {
+		t.Errorf("Note was not moved to %s: %s", d[0].Path, err)
+	}
+}
+
+func TestRemoveNoteKeep(t *testing.T) {
+	defer func(p string) { *vanishedFlag = p }(*vanishedFlag)
+	*vanishedFlag = "keep"
+	target, repo, l, dir := vanishedNote(t)
+	defer os.RemoveAll(target.destDir)
+
+	if err := target.removeNote(repo, l, "g1", repository.ReasonTrashed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Hello.html")); err != nil {
+		t.Errorf("Expected note to be kept: %s", err)
+	}
+	e, ok := repo.Get("g1")
+	if !ok || e.Departed == nil || e.Departed.Reason != repository.ReasonTrashed {
+		t.Fatalf("Expected note to be kept as departed, got %v", e)
+	}
+
+	// Later syncs don't record the departure again
+	if err := target.removeNote(repo, l, "g1", repository.ReasonMissing); err != nil {
+		t.Fatal(err)
+	}
+	if d := repo.Departures(); len(d) != 1 {
+		t.Errorf("Expected 1 departure, got %v", d)
+	}
+
+	// The note comes back and is gone again
+	guid := edam.GUID("g1")
+	title := "Hello"
+	active := true
+	target.recordNote(repo, l, "g1", &edam.Note{GUID: &guid, Title: &title, Active: &active})
+	if e.Departed != nil {
+		t.Errorf("Expected note to be back, got %v", e.Departed)
+	}
+	if err := target.removeNote(repo, l, "g1", repository.ReasonExpunged); err != nil {
+		t.Fatal(err)
+	}
+	if d := repo.Departures(); len(d) != 2 || d[1].Reason != repository.ReasonExpunged {
+		t.Errorf("Expected 2 departures, got %v", d)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Hello.html")); err != nil {
+		t.Errorf("Expected note to be kept: %s", err)
+	}
+}
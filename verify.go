@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+var (
+	// markdownLinkRegexp matches the targets of links and images in
+	// Markdown written by markdownConverter.
+	markdownLinkRegexp = regexp.MustCompile(`\]\((<[^>]*>|[^)\s]*)\)`)
+	// attachmentNameRegexp matches the names of attachments in an Obsidian
+	// vault, which start with the first 8 characters of their MD5 hash.
+	attachmentNameRegexp = regexp.MustCompile(`^([0-9a-f]{8})-`)
+)
+
+var verifyServerFlag = flag.Bool("verify_server", false, "With 'verify', also compare the backup with the server and report notes that are out of date")
+
+// Kinds of problems found by verify.
+const (
+	problemMissingNote     = "missing_note"
+	problemMissingFile     = "missing_file"
+	problemMissingResource = "missing_resource"
+	problemHashMismatch    = "hash_mismatch"
+	problemBadMetadata     = "bad_metadata"
+	problemOrphan          = "orphan"
+	problemOutOfDate       = "out_of_date"
+	problemNotBackedUp     = "not_backed_up"
+)
+
+// verifyReport is printed as JSON by the verify command.
+type verifyReport struct {
+	Notes int `json:"notes"`
+	// Unverified counts notes without note.json, whose attachments can't
+	// be checked.
+	Unverified int             `json:"unverified"`
+	Problems   []verifyProblem `json:"problems"`
+}
+
+type verifyProblem struct {
+	Kind  string `json:"kind"`
+	GUID  string `json:"guid,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Path is relative to --dest_dir.
+	Path   string `json:"path,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type verifier struct {
+	destDir string
+	report  *verifyReport
+}
+
+func (v verifier) problem(kind string, e *repository.Entry, path, detail string) {
+	p := verifyProblem{Kind: kind, Detail: detail}
+	if e != nil {
+		p.GUID, p.Title = e.GUID, e.Title
+	}
+	if path != "" {
+		rel, _ := filepath.Rel(v.destDir, path)
+		p.Path = filepath.ToSlash(rel)
+	}
+	v.report.Problems = append(v.report.Problems, p)
+}
+
+// verify checks the backup in --dest_dir and prints a report. It fails if
+// there are any problems.
+func verify() error {
+	lock, err := lockDestDir(*destDirFlag)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	report := &verifyReport{Problems: []verifyProblem{}}
+	v := verifier{*destDirFlag, report}
+	dirs := []string{*destDirFlag}
+	linked, _ := filepath.Glob(filepath.Join(*destDirFlag, linkedDirName, "*"))
+	dirs = append(dirs, linked...)
+	for _, dir := range dirs {
+		repo, err := repository.Load(dir)
+		if err != nil {
+			return err
+		}
+		layout, err := newNoteLayout(dir, repo)
+		if err != nil {
+			return err
+		}
+		if err := v.verifyRepo(dir, repo, layout); err != nil {
+			return err
+		}
+		if *verifyServerFlag && dir == *destDirFlag {
+			if err := v.verifyServer(repo); err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("Verification found %d problems", len(report.Problems))
+	}
+	return nil
+}
+
+func (v verifier) verifyRepo(dir string, repo *repository.Repo, layout noteLayout) error {
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		v.report.Notes++
+		path := layout.path(e)
+		if _, err := os.Stat(path); err != nil {
+			v.problem(problemMissingNote, e, path, "")
+			continue
+		}
+		switch l := layout.(type) {
+		case notebookLayout:
+			v.verifyNoteDir(l.noteDir(e), e)
+		case obsidianLayout:
+			if err := v.verifyMarkdownLinks(l, path, e); err != nil {
+				return err
+			}
+		}
+	}
+	if _, ok := layout.(obsidianLayout); ok {
+		if err := v.verifyAttachmentsDir(filepath.Join(dir, attachmentsDirName)); err != nil {
+			return err
+		}
+	}
+	orphans, err := findOrphans(dir, repo, layout)
+	if err != nil {
+		return err
+	}
+	for _, o := range orphans {
+		v.problem(problemOrphan, nil, o, "")
+	}
+	return nil
+}
+
+// verifyNoteDir checks that all files of a note exist, that its attachments
+// match their hashes, and that there are no unexpected files.
+func (v verifier) verifyNoteDir(dir string, e *repository.Entry) {
+	expected := map[string]bool{
+		noteMetadataFileName: true,
+		"files":              true,
+		versionsDirName:      true,
+	}
+	for _, f := range formats {
+		filename := noteFileName(dir, e.Title, noteFormats[f].extension)
+		if _, err := os.Stat(filename); err != nil {
+			v.problem(problemMissingFile, e, filename, "")
+		}
+	}
+	// Files of formats that were written by earlier runs aren't orphans.
+	for _, f := range noteFormats {
+		expected[filepath.Base(noteFileName(dir, e.Title, f.extension))] = true
+	}
+
+	m, err := readNoteMetadata(filepath.Join(dir, noteMetadataFileName))
+	if os.IsNotExist(err) {
+		v.report.Unverified++
+	} else if err != nil || m.Note == nil {
+		v.problem(problemBadMetadata, e, filepath.Join(dir, noteMetadataFileName), fmt.Sprint(err))
+	} else {
+		attachments := v.verifyResources(dir, e, m)
+		files, _ := ioutil.ReadDir(filepath.Join(dir, "files"))
+		for _, f := range files {
+			if !attachments["files/"+f.Name()] {
+				v.problem(problemOrphan, e, filepath.Join(dir, "files", f.Name()), "")
+			}
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		if !expected[f.Name()] {
+			v.problem(problemOrphan, e, filepath.Join(dir, f.Name()), "")
+		}
+	}
+}
+
+// verifyResources recomputes the MD5 of every attachment of a note and
+// compares it with the body hash stored on the server. It returns the paths
+// of all attachments, relative to the note's directory.
+func (v verifier) verifyResources(dir string, e *repository.Entry, m noteMetadata) map[string]bool {
+	byHash := make(map[string]resourceMetadata)
+	paths := make(map[string]bool)
+	for _, r := range m.Resources {
+		byHash[r.MD5] = r
+		for _, p := range []string{r.Path, r.Recognition, r.OCR, r.HOCR} {
+			if p != "" {
+				paths[p] = true
+			}
+		}
+	}
+	for _, r := range m.Note.Resources {
+		hash := hex.EncodeToString(r.GetData().GetBodyHash())
+		rm, ok := byHash[hash]
+		if !ok {
+			v.problem(problemMissingResource, e, "", fmt.Sprintf("resource %s with hash %s", r.GetGUID(), hash))
+			continue
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(rm.Path))
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			v.problem(problemMissingFile, e, filename, "")
+			continue
+		}
+		if sum := md5.Sum(body); hex.EncodeToString(sum[:]) != hash {
+			v.problem(problemHashMismatch, e, filename, fmt.Sprintf("expected %s, got %s", hash, hex.EncodeToString(sum[:])))
+		}
+	}
+	return paths
+}
+
+// verifyMarkdownLinks checks that the attachments a note of an Obsidian
+// vault links to exist.
+func (v verifier) verifyMarkdownLinks(l obsidianLayout, filename string, e *repository.Entry) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	attachments := filepath.Join(l.destDir, attachmentsDirName) + string(filepath.Separator)
+	for _, m := range markdownLinkRegexp.FindAllStringSubmatch(string(b), -1) {
+		target := strings.Replace(strings.TrimSuffix(strings.TrimPrefix(m[1], "<"), ">"), "%3E", ">", -1)
+		path := filepath.Join(filepath.Dir(filename), filepath.FromSlash(target))
+		if !strings.HasPrefix(path, attachments) {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			v.problem(problemMissingFile, e, path, "")
+		}
+	}
+	return nil
+}
+
+// verifyAttachmentsDir checks the attachments of an Obsidian vault against
+// the start of their MD5 hash, which is part of their names.
+func (v verifier) verifyAttachmentsDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasSuffix(name, recognitionSuffix) || strings.HasSuffix(name, ocrSuffix) || strings.HasSuffix(name, hocrSuffix) {
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		m := attachmentNameRegexp.FindStringSubmatch(name)
+		if m == nil {
+			v.problem(problemOrphan, nil, filename, "")
+			continue
+		}
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			v.problem(problemMissingFile, nil, filename, err.Error())
+			continue
+		}
+		sum := md5.Sum(body)
+		if got := hex.EncodeToString(sum[:]); !strings.HasPrefix(got, m[1]) {
+			v.problem(problemHashMismatch, nil, filename, fmt.Sprintf("expected %s..., got %s", m[1], got))
+		}
+	}
+	return nil
+}
+
+// verifyServer reports notes of the personal account that changed on the
+// server since they were backed up, or that were never backed up.
+func (v verifier) verifyServer(repo *repository.Repo) error {
+	notes, err := findNotesMetadata(runContext, ns, client.authToken, edam.NewNoteFilter())
+	if err != nil {
+		return err
+	}
+	for _, md := range notes {
+		e, ok := repo.Get(string(md.GetGUID()))
+		if !ok {
+			v.problem(problemNotBackedUp, &repository.Entry{GUID: string(md.GetGUID()), Title: md.GetTitle()}, "", "")
+			continue
+		}
+		if e.UpdateSequenceNum < int64(md.GetUpdateSequenceNum()) {
+			v.problem(problemOutOfDate, e, "", fmt.Sprintf("backed up USN %d, server USN %d", e.UpdateSequenceNum, md.GetUpdateSequenceNum()))
+		}
+	}
+	return nil
+}
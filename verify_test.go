@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestVerifyRepo(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	title := "Hello"
+	guid := edam.GUID("0c9d9a59-ff1c-4c32-b3a8-11b3f9ed9f3a")
+	resGUID := edam.GUID("res-guid")
+	mimeType := "text/plain"
+	fileName := "hello.txt"
+	sum := md5.Sum([]byte("data"))
+	res := &edam.Resource{
+		GUID:       &resGUID,
+		Mime:       &mimeType,
+		Data:       &edam.Data{Body: []byte("data"), BodyHash: sum[:]},
+		Attributes: &edam.ResourceAttributes{FileName: &fileName},
+	}
+	note := noteWithResources{
+		note:      &edam.Note{GUID: &guid, Title: &title, Resources: []*edam.Resource{res}},
+		resources: map[string]*edam.Resource{"8d777f385d3dfec8815d20f7496026dc": res},
+		destDir:   destDir,
+	}
+	repo := repository.New(destDir)
+	e := repo.GetOrAdd(string(guid))
+	e.Title = title
+	layout := notebookLayout{destDir, repo}
+	dir := layout.noteDir(e)
+
+	defer func(f []string) { formats = f }(formats)
+	formats = []string{"html"}
+	write := func(name, content string) {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Hello.html", "<html></html>")
+	write("files/hello.txt", "data")
+	if err := note.writeMetadata(filepath.Join(dir, noteMetadataFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	verifyKinds := func() []string {
+		v := verifier{destDir, &verifyReport{}}
+		if err := v.verifyRepo(destDir, repo, layout); err != nil {
+			t.Fatal(err)
+		}
+		var kinds []string
+		for _, p := range v.report.Problems {
+			kinds = append(kinds, p.Kind)
+		}
+		return kinds
+	}
+	if kinds := verifyKinds(); len(kinds) != 0 {
+		t.Errorf("Expected no problems, got %v", kinds)
+	}
+
+	write("files/hello.txt", "changed")
+	write("stray.txt", "")
+	kinds := verifyKinds()
+	if len(kinds) != 2 || kinds[0] != problemHashMismatch || kinds[1] != problemOrphan {
+		t.Errorf("Expected [%s %s], got %v", problemHashMismatch, problemOrphan, kinds)
+	}
+}
+
+func TestVerifyObsidianVault(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	e := repo.GetOrAdd("0c9d9a59-ff1c-4c32-b3a8-11b3f9ed9f3a")
+	e.Title = "Hello"
+	layout := obsidianLayout{destDir, repo}
+
+	sum := md5.Sum([]byte("data"))
+	attachment := filepath.Join(destDir, attachmentsDirName, hex.EncodeToString(sum[:])[:8]+"-my file.txt")
+	write := func(filename, content string) {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(attachment, "data")
+	write(filepath.Join(destDir, "Hello.md"), "---\nguid: \""+e.GUID+"\"\n---\n\n[my file.txt](<_attachments/"+filepath.Base(attachment)+">)\n")
+
+	verifyKinds := func() []string {
+		v := verifier{destDir, &verifyReport{}}
+		if err := v.verifyRepo(destDir, repo, layout); err != nil {
+			t.Fatal(err)
+		}
+		var kinds []string
+		for _, p := range v.report.Problems {
+			kinds = append(kinds, p.Kind)
+		}
+		return kinds
+	}
+	if kinds := verifyKinds(); len(kinds) != 0 {
+		t.Errorf("Expected no problems, got %v", kinds)
+	}
+
+	write(attachment, "changed")
+	if kinds := verifyKinds(); len(kinds) != 1 || kinds[0] != problemHashMismatch {
+		t.Errorf("Expected [%s], got %v", problemHashMismatch, kinds)
+	}
+
+	os.Remove(attachment)
+	if kinds := verifyKinds(); len(kinds) != 1 || kinds[0] != problemMissingFile {
+		t.Errorf("Expected [%s], got %v", problemMissingFile, kinds)
+	}
+}
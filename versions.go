@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/fileutil"
+	"github.com/asig/duplikator/repository"
+)
+
+// versionsDirName is the directory below a note that holds its earlier
+// versions, one directory per USN.
+const versionsDirName = "versions"
+
+var versionsFlag = flag.Bool("versions", false, "Also back up earlier versions of notes (Evernote Premium only)")
+
+// knownVersions returns the USNs of the versions stored in a note directory.
+func knownVersions(noteDir string) map[int32]bool {
+	res := make(map[int32]bool)
+	dirs, _ := ioutil.ReadDir(filepath.Join(noteDir, versionsDirName))
+	for _, d := range dirs {
+		if usn, err := strconv.ParseInt(d.Name(), 10, 32); err == nil && d.IsDir() {
+			res[int32(usn)] = true
+		}
+	}
+	return res
+}
+
+// fetchVersions downloads the earlier versions of a note, except for the
+// ones that are already backed up.
+func (t *backupTarget) fetchVersions(ctx context.Context, guid string) ([]noteWithResources, error) {
+	versions, err := t.ns.ListNoteVersions(ctx, t.authToken, edam.GUID(guid))
+	if err != nil {
+		if _, ok := err.(*edam.EDAMUserException); ok {
+			// Not available for this account
+			log.Printf("Can't list versions of note %s: %s", guid, err)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	known := t.knownVersions[guid]
+	// With a blob store, attachments are only downloaded if they aren't
+	// there yet.
+	store := blobs(t.destDir)
+	res := []noteWithResources{}
+	for _, v := range versions {
+		if known[v.UpdateSequenceNum] {
+			continue
+		}
+		log.Printf("Downloading version %d of note %q", v.UpdateSequenceNum, v.Title)
+		n, err := t.ns.GetNoteVersion(ctx, t.authToken, edam.GUID(guid), v.UpdateSequenceNum, store == "", false, false)
+		if err != nil {
+			return nil, err
+		}
+		if store != "" {
+			if err := t.loadVersionData(ctx, store, guid, n); err != nil {
+				return nil, err
+			}
+		}
+		version := noteWithResources{note: n, destDir: t.destDir, resources: make(map[string]*edam.Resource)}
+		for _, r := range n.Resources {
+			version.resources[hex.EncodeToString(r.GetData().GetBodyHash())] = r
+		}
+		res = append(res, version)
+	}
+	return res, nil
+}
+
+// loadVersionData fills in the attachments of a version that was fetched
+// without them from the blob store. If some are missing there, the version is
+// fetched again with its attachments, as the resources of earlier versions
+// can't be downloaded on their own.
+func (t *backupTarget) loadVersionData(ctx context.Context, store blobStore, guid string, n *edam.Note) error {
+	var missing []*edam.Resource
+	for _, r := range n.Resources {
+		if r.Data == nil {
+			continue
+		}
+		if body, ok := store.get(hex.EncodeToString(r.Data.BodyHash)); ok {
+			r.Data.Body = body
+		} else {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	log.Printf("Downloading %d attachments of version %d of note %q", len(missing), n.GetUpdateSequenceNum(), n.GetTitle())
+	full, err := t.ns.GetNoteVersion(ctx, t.authToken, edam.GUID(guid), n.GetUpdateSequenceNum(), true, false, false)
+	if err != nil {
+		return err
+	}
+	bodies := make(map[string][]byte)
+	for _, r := range full.Resources {
+		if r.Data != nil {
+			bodies[hex.EncodeToString(r.Data.BodyHash)] = r.Data.Body
+		}
+	}
+	for _, r := range missing {
+		hash := hex.EncodeToString(r.Data.BodyHash)
+		body, ok := bodies[hash]
+		if !ok {
+			return fmt.Errorf("attachment %s of version %d of note %s is missing", hash, n.GetUpdateSequenceNum(), guid)
+		}
+		r.Data.Body = body
+	}
+	return nil
+}
+
+// notesWithoutVersions returns the GUIDs of the notes of source that are not
+// downloaded in this sync and don't have a versions directory yet, e.g.
+// because they were backed up before --versions was set.
+func notesWithoutVersions(repo *repository.Repo, l notebookLayout, source string, download []string) []string {
+	downloading := make(map[string]bool)
+	for _, guid := range download {
+		downloading[guid] = true
+	}
+	var res []string
+	for _, guid := range repo.GUIDs() {
+		e, _ := repo.Get(guid)
+		if downloading[guid] || e.Source != source || e.Departed != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(l.noteDir(e), versionsDirName)); os.IsNotExist(err) {
+			res = append(res, guid)
+		}
+	}
+	return res
+}
+
+// backfillVersions downloads the earlier versions of the notes returned by
+// notesWithoutVersions. The versions directory is created even if a note has
+// no earlier versions, so that it isn't asked for again.
+func (t *backupTarget) backfillVersions(ctx context.Context, repo *repository.Repo, l notebookLayout, download []string) error {
+	for _, guid := range notesWithoutVersions(repo, l, t.source, download) {
+		e, _ := repo.Get(guid)
+		versions, err := t.fetchVersions(ctx, guid)
+		if err != nil {
+			return err
+		}
+		versionsDir := filepath.Join(l.noteDir(e), versionsDirName)
+		tmp, err := fileutil.TempDir(versionsDir)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			dir := filepath.Join(tmp, fmt.Sprint(v.note.GetUpdateSequenceNum()))
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				break
+			}
+			if err = v.writeTo(dir); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = fileutil.ReplaceDir(tmp, versionsDir)
+		}
+		if err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/asig/duplikator/edam"
+	"github.com/asig/duplikator/repository"
+)
+
+func TestSaveKeepsVersions(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	defer func(f []string) { formats = f }(formats)
+	formats = []string{"html"}
+
+	newNote := func(usn int32) noteWithResources {
+		guid := edam.GUID("g1")
+		title := "Hello"
+		content := enmlHeader + "<en-note>Hi</en-note>"
+		return noteWithResources{
+			note:    &edam.Note{GUID: &guid, Title: &title, Content: &content, UpdateSequenceNum: &usn},
+			destDir: destDir,
+		}
+	}
+
+	note := newNote(7)
+	note.versions = []noteWithResources{newNote(5)}
+	if err := note.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := newNote(9).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := note.baseName()
+	if _, err := os.Stat(filepath.Join(dir, versionsDirName, "5", "Hello.html")); err != nil {
+		t.Errorf("Version 5 was not kept: %s", err)
+	}
+	if known := knownVersions(dir); len(known) != 1 || !known[5] {
+		t.Errorf("Expected version 5 to be known, got %v", known)
+	}
+}
+
+func TestNotesWithoutVersions(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	repo := repository.New(destDir)
+	l := notebookLayout{destDir, repo}
+	for _, guid := range []string{"a", "b", "c"} {
+		repo.Add(&repository.Entry{GUID: guid, Title: "Note " + guid})
+	}
+	repo.Add(&repository.Entry{GUID: "d", Title: "Business", Source: repository.SourceBusiness})
+	repo.Add(&repository.Entry{GUID: "e", Title: "Departed", Departed: &repository.Departure{GUID: "e"}})
+	e, _ := repo.Get("b")
+	if err := os.MkdirAll(filepath.Join(l.noteDir(e), versionsDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got := notesWithoutVersions(repo, l, "", []string{"c"})
+	if len(got) != 1 || got[0] != "a" {
+		t.Errorf("Expected [a], got %v", got)
+	}
+}
+
+// versionsNoteStore serves earlier versions of a note, with attachments only
+// if they are asked for.
+type versionsNoteStore struct {
+	edam.NoteStore
+	versions []*edam.Note
+	// withData records for every GetNoteVersion call whether attachments
+	// were asked for.
+	withData []bool
+}
+
+func (s *versionsNoteStore) ListNoteVersions(ctx context.Context, authenticationToken string, noteGuid edam.GUID) ([]*edam.NoteVersionId, error) {
+	var res []*edam.NoteVersionId
+	for _, v := range s.versions {
+		res = append(res, &edam.NoteVersionId{UpdateSequenceNum: v.GetUpdateSequenceNum(), Title: v.GetTitle()})
+	}
+	return res, nil
+}
+
+func (s *versionsNoteStore) GetNoteVersion(ctx context.Context, authenticationToken string, noteGuid edam.GUID, updateSequenceNum int32, withResourcesData bool, withResourcesRecognition bool, withResourcesAlternateData bool) (*edam.Note, error) {
+	s.withData = append(s.withData, withResourcesData)
+	for _, v := range s.versions {
+		if v.GetUpdateSequenceNum() != updateSequenceNum {
+			continue
+		}
+		n := *v
+		n.Resources = nil
+		for _, r := range v.Resources {
+			c := *r
+			if !withResourcesData {
+				c.Data = &edam.Data{BodyHash: r.Data.BodyHash, Size: r.Data.Size}
+			}
+			n.Resources = append(n.Resources, &c)
+		}
+		return &n, nil
+	}
+	return nil, &edam.EDAMNotFoundException{}
+}
+
+func TestFetchVersionsFromBlobStore(t *testing.T) {
+	destDir, err := ioutil.TempDir("", "versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	*attachmentsFlag = "blobs"
+	defer func() { *attachmentsFlag = "copy" }()
+
+	version := func(usn int32, body string) *edam.Note {
+		title := "Hello"
+		hash := md5.Sum([]byte(body))
+		return &edam.Note{Title: &title, UpdateSequenceNum: &usn, Resources: []*edam.Resource{{Data: &edam.Data{Body: []byte(body), BodyHash: hash[:]}}}}
+	}
+	ns := &versionsNoteStore{versions: []*edam.Note{version(1, "known"), version(2, "new")}}
+	hash := md5.Sum([]byte("known"))
+	if err := blobs(destDir).put(hex.EncodeToString(hash[:]), []byte("known")); err != nil {
+		t.Fatal(err)
+	}
+
+	target := &backupTarget{ns: ns, destDir: destDir}
+	versions, err := target.fetchVersions(context.Background(), "g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Version 1 comes from the blob store, version 2 is fetched again with
+	// its attachments
+	if expected := []bool{false, false, true}; !reflect.DeepEqual(ns.withData, expected) {
+		t.Errorf("Expected GetNoteVersion calls with data %v, got %v", expected, ns.withData)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+	for i, body := range []string{"known", "new"} {
+		if got := string(versions[i].note.Resources[0].Data.Body); got != body {
+			t.Errorf("Expected attachment %q of version %d, got %q", body, i+1, got)
+		}
+	}
+}
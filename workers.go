@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	gosync "sync"
+)
+
+var parallelismFlag = flag.Int("parallelism", 1, "Number of notes to download concurrently")
+
+// noteFetcher downloads a note. A noteFetcher must only be used by one
+// goroutine at a time.
+type noteFetcher func(guid string, ctx context.Context) (noteWithResources, error)
+
+// fetchers returns n noteFetchers for the target, each with its own note
+// store.
+func (t *backupTarget) fetchers(n int) ([]noteFetcher, error) {
+	res := []noteFetcher{t.logFetch(t.fetchNote)}
+	for len(res) < n {
+		ns, err := client.getNoteStoreForURL(t.noteStoreURL)
+		if err != nil {
+			return nil, err
+		}
+		w := *t
+		w.ns = ns
+		res = append(res, t.logFetch(w.fetchNote))
+	}
+	return res, nil
+}
+
+func (t *backupTarget) logFetch(fetch noteFetcher) noteFetcher {
+	return func(guid string, ctx context.Context) (noteWithResources, error) {
+		log.Printf("Downloading Note %s", guid)
+		return fetch(guid, ctx)
+	}
+}
+
+type fetchResult struct {
+	guid string
+	note noteWithResources
+	err  error
+}
+
+// fetchNotes downloads the notes with the given GUIDs, one worker per
+// fetcher. handle is called for every note, including the ones that failed
+// to download, in the caller's goroutine. If handle returns an error, the
+// remaining downloads are cancelled and the error is returned. If ctx is
+// cancelled, fetchNotes stops and returns ctx.Err().
+func fetchNotes(parent context.Context, guids []string, fetchers []noteFetcher, handle func(guid string, note noteWithResources, err error) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, guid := range guids {
+			select {
+			case jobs <- guid:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan fetchResult)
+	var wg gosync.WaitGroup
+	for _, fetch := range fetchers {
+		wg.Add(1)
+		go func(fetch noteFetcher) {
+			defer wg.Done()
+			for guid := range jobs {
+				note, err := fetch(guid, ctx)
+				select {
+				case results <- fetchResult{guid, note, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(fetch)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+	for r := range results {
+		if err != nil {
+			// Drain the remaining results
+			continue
+		}
+		if err = handle(r.guid, r.note, r.err); err != nil {
+			cancel()
+		}
+	}
+	if err == nil {
+		err = parent.Err()
+	}
+	return err
+}
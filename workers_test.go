@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2019 Andreas Signer <asigner@gmail.com>
+ *
+ * This file is part of Duplikator.
+ *
+ * Duplikator is free software: you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Duplikator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Duplikator.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFetchNotes(t *testing.T) {
+	var guids []string
+	for i := 0; i < 50; i++ {
+		guids = append(guids, fmt.Sprintf("guid-%d", i))
+	}
+	fetch := func(guid string, ctx context.Context) (noteWithResources, error) {
+		return noteWithResources{destDir: guid}, nil
+	}
+	fetchers := []noteFetcher{fetch, fetch, fetch, fetch}
+
+	handled := make(map[string]bool)
+	err := fetchNotes(context.Background(), guids, fetchers, func(guid string, note noteWithResources, err error) error {
+		if note.destDir != guid {
+			t.Errorf("Got note %s for %s", note.destDir, guid)
+		}
+		handled[guid] = true
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != len(guids) {
+		t.Errorf("Expected %d notes, got %d", len(guids), len(handled))
+	}
+
+	failure := errors.New("failure")
+	calls := 0
+	err = fetchNotes(context.Background(), guids, fetchers, func(guid string, note noteWithResources, err error) error {
+		calls++
+		return failure
+	})
+	if err != failure || calls != 1 {
+		t.Errorf("Expected to stop after first error, got %v after %d calls", err, calls)
+	}
+}